@@ -0,0 +1,151 @@
+// Package adps parses and reasons about the ADPS permission strings and
+// URI patterns used in access DOTs, so that they can be checked locally
+// before they are sent to the router.
+package adps
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Level is how much wildcarding a consume or tap permission allows
+type Level int
+
+const (
+	// The permission is not granted
+	None Level = iota
+	// Only URIs without wildcards, e.g. "C"
+	Plain
+	// URIs with + wildcards, e.g. "C+"
+	Plus
+	// URIs with + and * wildcards, e.g. "C*"
+	Star
+)
+
+func (l Level) suffix() string {
+	switch l {
+	case Plus:
+		return "+"
+	case Star:
+		return "*"
+	}
+	return ""
+}
+
+// Permissions is a parsed ADPS permission string such as "LPC*"
+type Permissions struct {
+	Consume Level
+	Tap     Level
+	Publish bool
+	List    bool
+}
+
+// Parse parses an ADPS permission string. The letters may appear in any
+// order and repeats are merged, but anything other than C, T, P and L, or
+// a + or * not following C or T, is an error.
+func Parse(s string) (Permissions, error) {
+	rv := Permissions{}
+	if s == "" {
+		return rv, fmt.Errorf("empty permission string")
+	}
+	for i := 0; i < len(s); i++ {
+		lvl := Plain
+		if i+1 < len(s) && (s[i+1] == '+' || s[i+1] == '*') {
+			if s[i] != 'C' && s[i] != 'T' {
+				return rv, fmt.Errorf("permission %q: %c cannot follow %c", s, s[i+1], s[i])
+			}
+			lvl = Plus
+			if s[i+1] == '*' {
+				lvl = Star
+			}
+		}
+		switch s[i] {
+		case 'C':
+			if lvl > rv.Consume {
+				rv.Consume = lvl
+			}
+		case 'T':
+			if lvl > rv.Tap {
+				rv.Tap = lvl
+			}
+		case 'P':
+			rv.Publish = true
+		case 'L':
+			rv.List = true
+		default:
+			return rv, fmt.Errorf("permission %q: unknown permission %q", s, s[i])
+		}
+		if lvl != Plain {
+			i++
+		}
+	}
+	return rv, nil
+}
+
+// Validate checks that s is a valid ADPS permission string
+func Validate(s string) error {
+	_, err := Parse(s)
+	return err
+}
+
+// String returns the canonical form of the permissions, e.g. "C*PL"
+func (p Permissions) String() string {
+	b := &strings.Builder{}
+	if p.Consume != None {
+		b.WriteString("C" + p.Consume.suffix())
+	}
+	if p.Tap != None {
+		b.WriteString("T" + p.Tap.suffix())
+	}
+	if p.Publish {
+		b.WriteString("P")
+	}
+	if p.List {
+		b.WriteString("L")
+	}
+	return b.String()
+}
+
+// IsEmpty returns true if no permission is granted
+func (p Permissions) IsEmpty() bool {
+	return p == Permissions{}
+}
+
+// Includes returns true if p grants everything that o grants. C* includes
+// C+ which includes C, and likewise for T.
+func (p Permissions) Includes(o Permissions) bool {
+	return p.Consume >= o.Consume && p.Tap >= o.Tap &&
+		(p.Publish || !o.Publish) && (p.List || !o.List)
+}
+
+// Intersect returns the permissions granted by both p and o, which is
+// what a chain through DOTs with p and o grants
+func (p Permissions) Intersect(o Permissions) Permissions {
+	rv := Permissions{
+		Consume: p.Consume,
+		Tap:     p.Tap,
+		Publish: p.Publish && o.Publish,
+		List:    p.List && o.List,
+	}
+	if o.Consume < rv.Consume {
+		rv.Consume = o.Consume
+	}
+	if o.Tap < rv.Tap {
+		rv.Tap = o.Tap
+	}
+	return rv
+}
+
+// Includes parses both permission strings and returns true if have grants
+// everything in want
+func Includes(have, want string) (bool, error) {
+	h, err := Parse(have)
+	if err != nil {
+		return false, err
+	}
+	w, err := Parse(want)
+	if err != nil {
+		return false, err
+	}
+	return h.Includes(w), nil
+}
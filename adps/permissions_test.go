@@ -0,0 +1,115 @@
+package adps
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		in  string
+		out Permissions
+		str string
+		err bool
+	}{
+		{"C", Permissions{Consume: Plain}, "C", false},
+		{"C+", Permissions{Consume: Plus}, "C+", false},
+		{"C*", Permissions{Consume: Star}, "C*", false},
+		{"T+", Permissions{Tap: Plus}, "T+", false},
+		{"PL", Permissions{Publish: true, List: true}, "PL", false},
+		{"LPC*", Permissions{Consume: Star, Publish: true, List: true}, "C*PL", false},
+		{"C*T*PL", Permissions{Consume: Star, Tap: Star, Publish: true, List: true}, "C*T*PL", false},
+		{"CC*C+", Permissions{Consume: Star}, "C*", false},
+		{"PP", Permissions{Publish: true}, "P", false},
+		{"T*C", Permissions{Consume: Plain, Tap: Star}, "CT*", false},
+		{"", Permissions{}, "", true},
+		{"X", Permissions{}, "", true},
+		{"c", Permissions{}, "", true},
+		{"P*", Permissions{}, "", true},
+		{"L+", Permissions{}, "", true},
+		{"+", Permissions{}, "", true},
+		{"*C", Permissions{}, "", true},
+		{"C**", Permissions{}, "", true},
+		{"C+*", Permissions{}, "", true},
+	}
+	for _, c := range cases {
+		p, err := Parse(c.in)
+		if c.err {
+			if err == nil {
+				t.Errorf("Parse(%q) = %v, expected an error", c.in, p)
+			}
+			if Validate(c.in) == nil {
+				t.Errorf("Validate(%q) accepted an invalid string", c.in)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Parse(%q): %v", c.in, err)
+			continue
+		}
+		if p != c.out {
+			t.Errorf("Parse(%q) = %+v, expected %+v", c.in, p, c.out)
+		}
+		if p.String() != c.str {
+			t.Errorf("Parse(%q).String() = %q, expected %q", c.in, p.String(), c.str)
+		}
+	}
+	if !(Permissions{}).IsEmpty() || (Permissions{List: true}).IsEmpty() {
+		t.Error("IsEmpty is wrong")
+	}
+}
+
+func TestIncludes(t *testing.T) {
+	cases := []struct {
+		have, want string
+		ok         bool
+	}{
+		{"C*", "C", true},
+		{"C*", "C+", true},
+		{"C*", "C*", true},
+		{"C+", "C*", false},
+		{"C", "C+", false},
+		{"C*T*PL", "C*T*PL", true},
+		{"C*T*PL", "P", true},
+		{"C*P", "PL", false},
+		{"T*", "C", false},
+		{"C*", "T", false},
+		{"PL", "L", true},
+		{"L", "P", false},
+	}
+	for _, c := range cases {
+		ok, err := Includes(c.have, c.want)
+		if err != nil || ok != c.ok {
+			t.Errorf("Includes(%q, %q) = %v, %v; expected %v", c.have, c.want, ok, err, c.ok)
+		}
+	}
+	if _, err := Includes("C", "Q"); err == nil {
+		t.Error("expected an error for an invalid want")
+	}
+	if _, err := Includes("", "C"); err == nil {
+		t.Error("expected an error for an invalid have")
+	}
+}
+
+func TestIntersect(t *testing.T) {
+	cases := []struct {
+		a, b, out string
+	}{
+		{"C*T*PL", "C+P", "C+P"},
+		{"C*", "C", "C"},
+		{"C+T*", "C*T+", "C+T+"},
+		{"PL", "C*T*", ""},
+		{"C*PL", "C*PL", "C*PL"},
+		{"LP", "L", "L"},
+	}
+	for _, c := range cases {
+		a, _ := Parse(c.a)
+		b, _ := Parse(c.b)
+		if s := a.Intersect(b).String(); s != c.out {
+			t.Errorf("%q ∩ %q = %q, expected %q", c.a, c.b, s, c.out)
+		}
+		if a.Intersect(b) != b.Intersect(a) {
+			t.Errorf("%q ∩ %q is not symmetric", c.a, c.b)
+		}
+		if !a.Includes(a.Intersect(b)) || !b.Includes(a.Intersect(b)) {
+			t.Errorf("%q ∩ %q is not included in both", c.a, c.b)
+		}
+	}
+}
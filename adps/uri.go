@@ -0,0 +1,179 @@
+package adps
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ValidatePattern checks that a URI pattern has a namespace, no empty
+// segments, + only as a whole segment and at most one * segment
+func ValidatePattern(pattern string) error {
+	parts := strings.Split(pattern, "/")
+	if parts[0] == "" || parts[0] == "+" || parts[0] == "*" {
+		return fmt.Errorf("uri %q must start with a namespace", pattern)
+	}
+	stars := 0
+	for _, p := range parts {
+		switch {
+		case p == "":
+			return fmt.Errorf("uri %q has an empty segment", pattern)
+		case p == "*":
+			stars++
+		case p == "+":
+		case strings.ContainsAny(p, "+*"):
+			return fmt.Errorf("uri %q has a wildcard inside segment %q", pattern, p)
+		}
+	}
+	if stars > 1 {
+		return fmt.Errorf("uri %q has more than one *", pattern)
+	}
+	return nil
+}
+
+// ValidateSuffix is like ValidatePattern but for a pattern without the
+// namespace, as found in DOTs
+func ValidateSuffix(suffix string) error {
+	return ValidatePattern("ns/" + suffix)
+}
+
+// HasWildcards returns true if the pattern contains + or *
+func HasWildcards(pattern string) bool {
+	for _, p := range strings.Split(pattern, "/") {
+		if p == "+" || p == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// Wildcards returns the consume level needed to subscribe to the pattern
+func Wildcards(pattern string) Level {
+	rv := Plain
+	for _, p := range strings.Split(pattern, "/") {
+		if p == "*" {
+			return Star
+		}
+		if p == "+" {
+			rv = Plus
+		}
+	}
+	return rv
+}
+
+// Matches returns true if the URI, which must not contain wildcards,
+// matches the pattern
+func Matches(pattern, uri string) bool {
+	return covers(strings.Split(pattern, "/"), strings.Split(uri, "/"))
+}
+
+// Covers returns true if every URI matched by pattern b is also matched by
+// pattern a. Both patterns must be valid.
+func Covers(a, b string) bool {
+	return covers(strings.Split(a, "/"), strings.Split(b, "/"))
+}
+
+func covers(a, b []string) bool {
+	bstar := -1
+	for i, s := range b {
+		if s == "*" {
+			bstar = i
+		}
+	}
+	if bstar < 0 {
+		return coversFixed(a, b)
+	}
+	astar := -1
+	for i, s := range a {
+		if s == "*" {
+			astar = i
+		}
+	}
+	if astar < 0 {
+		// b matches URIs of any length, a does not
+		return false
+	}
+	// a's * absorbs the middle of every URI b matches, so a's prefix and
+	// suffix must cover b's. Where they reach past b's prefix or suffix
+	// they cover segments of b's * expansion, which only + can do.
+	apre, asuf := a[:astar], a[astar+1:]
+	bpre, bsuf := b[:bstar], b[bstar+1:]
+	if len(apre)+len(asuf) > len(bpre)+len(bsuf) {
+		return false
+	}
+	for i, s := range apre {
+		if (i < len(bpre) && !segCovers(s, bpre[i])) || (i >= len(bpre) && s != "+") {
+			return false
+		}
+	}
+	for i := 1; i <= len(asuf); i++ {
+		s := asuf[len(asuf)-i]
+		if (i <= len(bsuf) && !segCovers(s, bsuf[len(bsuf)-i])) || (i > len(bsuf) && s != "+") {
+			return false
+		}
+	}
+	return true
+}
+
+func segCovers(a, b string) bool {
+	return a == "+" || a == b
+}
+
+// coversFixed handles a b without *, so it matches URIs of one length
+func coversFixed(a, b []string) bool {
+	if len(a) == 0 {
+		return len(b) == 0
+	}
+	if a[0] == "*" {
+		for i := 0; i <= len(b); i++ {
+			if coversFixed(a[1:], b[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	return len(b) > 0 && segCovers(a[0], b[0]) && coversFixed(a[1:], b[1:])
+}
+
+// Overlaps returns true if some URI matches both patterns
+func Overlaps(a, b string) bool {
+	return overlaps(strings.Split(a, "/"), strings.Split(b, "/"))
+}
+
+func overlaps(a, b []string) bool {
+	switch {
+	case len(a) == 0:
+		return allStars(b)
+	case len(b) == 0:
+		return allStars(a)
+	case a[0] == "*":
+		return overlaps(a[1:], b) || overlaps(a, b[1:])
+	case b[0] == "*":
+		return overlaps(a, b[1:]) || overlaps(a[1:], b)
+	case a[0] == "+" || b[0] == "+" || a[0] == b[0]:
+		return overlaps(a[1:], b[1:])
+	}
+	return false
+}
+
+func allStars(p []string) bool {
+	for _, s := range p {
+		if s != "*" {
+			return false
+		}
+	}
+	return true
+}
+
+// Grants returns true if a DOT granting have on pattern grants want on the
+// URI, which may itself be a pattern. The consume and tap levels in want
+// are raised to what the wildcards in uri need.
+func Grants(pattern string, have Permissions, uri string, want Permissions) bool {
+	lvl := Wildcards(uri)
+	if want.Consume != None && want.Consume < lvl {
+		want.Consume = lvl
+	}
+	if want.Tap != None && want.Tap < lvl {
+		want.Tap = lvl
+	}
+	return have.Includes(want) && Covers(pattern, uri)
+}
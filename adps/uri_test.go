@@ -0,0 +1,182 @@
+package adps
+
+import "testing"
+
+func TestValidatePattern(t *testing.T) {
+	cases := []struct {
+		in  string
+		err bool
+	}{
+		{"ns", false},
+		{"ns/a/b", false},
+		{"ns/+/b", false},
+		{"ns/*", false},
+		{"ns/a/*/b", false},
+		{"ns/+/*/+", false},
+		{"", true},
+		{"+/a", true},
+		{"*", true},
+		{"ns/", true},
+		{"ns//a", true},
+		{"ns/a+", true},
+		{"ns/a*b", true},
+		{"ns/*/a/*", true},
+		{"/ns/a", true},
+	}
+	for _, c := range cases {
+		if err := ValidatePattern(c.in); (err != nil) != c.err {
+			t.Errorf("ValidatePattern(%q) = %v, expected error %v", c.in, err, c.err)
+		}
+	}
+	if ValidateSuffix("a/*") != nil || ValidateSuffix("") == nil {
+		t.Error("ValidateSuffix is wrong")
+	}
+}
+
+func TestWildcards(t *testing.T) {
+	cases := []struct {
+		in  string
+		lvl Level
+	}{
+		{"ns/a/b", Plain},
+		{"ns/+/b", Plus},
+		{"ns/a/*", Star},
+		{"ns/+/*", Star},
+	}
+	for _, c := range cases {
+		if l := Wildcards(c.in); l != c.lvl {
+			t.Errorf("Wildcards(%q) = %d, expected %d", c.in, l, c.lvl)
+		}
+		if HasWildcards(c.in) != (c.lvl != Plain) {
+			t.Errorf("HasWildcards(%q) is wrong", c.in)
+		}
+	}
+}
+
+func TestMatches(t *testing.T) {
+	cases := []struct {
+		pattern, uri string
+		ok           bool
+	}{
+		{"ns/a/b", "ns/a/b", true},
+		{"ns/a/b", "ns/a/c", false},
+		{"ns/a/b", "ns/a", false},
+		{"ns/+/b", "ns/x/b", true},
+		{"ns/+/b", "ns/b", false},
+		{"ns/+/b", "ns/x/y/b", false},
+		{"ns/a/*", "ns/a/x/y", true},
+		// * matches zero segments too
+		{"ns/a/*", "ns/a", true},
+		{"ns/a/*/b", "ns/a/b", true},
+		{"ns/a/*/b", "ns/a/x/y/b", true},
+		{"ns/a/*/b", "ns/a/x/y", false},
+		{"ns/*", "ns", true},
+		{"ns/*", "other/a", false},
+		{"ns/+/*", "ns", false},
+		{"ns/+/*", "ns/x", true},
+	}
+	for _, c := range cases {
+		if ok := Matches(c.pattern, c.uri); ok != c.ok {
+			t.Errorf("Matches(%q, %q) = %v, expected %v", c.pattern, c.uri, ok, c.ok)
+		}
+	}
+}
+
+func TestCovers(t *testing.T) {
+	cases := []struct {
+		a, b string
+		ok   bool
+	}{
+		{"ns/a", "ns/a", true},
+		{"ns/+", "ns/a", true},
+		{"ns/a", "ns/+", false},
+		{"ns/+/b", "ns/+/b", true},
+		{"ns/*", "ns/a/+/b", true},
+		{"ns/*", "ns/*", true},
+		{"ns/*", "ns/a/*", true},
+		{"ns/a/*", "ns/*", false},
+		{"ns/+", "ns/*", false},
+		{"ns/*/b", "ns/a/*/b", true},
+		{"ns/*/b", "ns/a/*", false},
+		{"ns/a/*/b", "ns/a/*/b", true},
+		{"ns/a/*/b", "ns/a/b", true},
+		{"ns/a/*/b", "ns/a/*/c/b", true},
+		{"ns/+/*", "ns/a/*", true},
+		// b's * can expand to nothing, which ns/+/* does not match
+		{"ns/+/*", "ns/*", false},
+		{"ns/*/+", "ns/a/*", true},
+		{"ns/*/+", "ns/*", false},
+		{"ns/*/+", "ns/*/a", true},
+		{"ns/a/*/+/b", "ns/a/*/x/b", true},
+		{"ns/a/*/+/b", "ns/a/*/b", false},
+		{"other/*", "ns/a", false},
+	}
+	for _, c := range cases {
+		if ok := Covers(c.a, c.b); ok != c.ok {
+			t.Errorf("Covers(%q, %q) = %v, expected %v", c.a, c.b, ok, c.ok)
+		}
+	}
+}
+
+func TestOverlaps(t *testing.T) {
+	cases := []struct {
+		a, b string
+		ok   bool
+	}{
+		{"ns/a", "ns/a", true},
+		{"ns/a", "ns/b", false},
+		{"ns/+", "ns/b", true},
+		{"ns/+/c", "ns/b/+", true},
+		{"ns/+", "ns/a/b", false},
+		{"ns/*", "ns/a/b", true},
+		{"ns/a/*", "ns/b/*", false},
+		{"ns/a/*", "ns/*/b", true},
+		{"ns/*", "ns", true},
+		{"ns/a/*", "ns", false},
+		{"ns/*/x", "ns/y/*", true},
+		{"ns/+/*", "ns", false},
+	}
+	for _, c := range cases {
+		if ok := Overlaps(c.a, c.b); ok != c.ok {
+			t.Errorf("Overlaps(%q, %q) = %v, expected %v", c.a, c.b, ok, c.ok)
+		}
+		if ok := Overlaps(c.b, c.a); ok != c.ok {
+			t.Errorf("Overlaps(%q, %q) = %v, expected %v", c.b, c.a, ok, c.ok)
+		}
+	}
+}
+
+func TestGrants(t *testing.T) {
+	cases := []struct {
+		pattern, have, uri, want string
+		ok                       bool
+	}{
+		{"ns/a/*", "C*", "ns/a/b", "C", true},
+		{"ns/a/*", "C", "ns/a/b", "C", true},
+		// subscribing to a + or * pattern needs C+ or C*
+		{"ns/a/*", "C", "ns/a/+", "C", false},
+		{"ns/a/*", "C+", "ns/a/+", "C", true},
+		{"ns/a/*", "C+", "ns/a/*", "C", false},
+		{"ns/a/*", "C*", "ns/a/*", "C", true},
+		{"ns/a/*", "T+", "ns/a/+", "T", true},
+		// publish is not raised by wildcards
+		{"ns/a/*", "P", "ns/a/+", "P", true},
+		{"ns/a/*", "C*P", "ns/b", "P", false},
+		{"ns/a/+", "C*", "ns/a/*", "C*", false},
+		{"ns/a", "PL", "ns/a", "PL", true},
+		{"ns/a", "P", "ns/a", "PL", false},
+	}
+	for _, c := range cases {
+		have, err := Parse(c.have)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := Parse(c.want)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok := Grants(c.pattern, have, c.uri, want); ok != c.ok {
+			t.Errorf("Grants(%q, %q, %q, %q) = %v, expected %v", c.pattern, c.have, c.uri, c.want, ok, c.ok)
+		}
+	}
+}
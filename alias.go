@@ -0,0 +1,178 @@
+package bw2bind
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Alias is a 32 byte long alias key or value. Strings are stored left
+// aligned and padded on the right with zero bytes.
+type Alias [32]byte
+
+// ParseAlias encodes a string as an Alias. It must be 1 to 32 bytes long
+// and must not contain zero bytes, as those are the padding.
+func ParseAlias(s string) (Alias, error) {
+	rv := Alias{}
+	if s == "" {
+		return rv, errors.New("empty alias")
+	}
+	if len(s) > 32 {
+		return rv, fmt.Errorf("alias %q is longer than 32 bytes", s)
+	}
+	if strings.IndexByte(s, 0) >= 0 {
+		return rv, fmt.Errorf("alias %q contains a zero byte", s)
+	}
+	copy(rv[:], s)
+	return rv, nil
+}
+
+// AliasFromBytes pads a value of up to 32 bytes to an Alias
+func AliasFromBytes(b []byte) (Alias, error) {
+	rv := Alias{}
+	if len(b) > 32 {
+		return rv, errors.New("alias value is longer than 32 bytes")
+	}
+	copy(rv[:], b)
+	return rv, nil
+}
+
+// IsZero returns true if the alias is all zeroes, which is how the
+// registry represents an unset alias
+func (a Alias) IsZero() bool {
+	return a == Alias{}
+}
+
+// Bytes returns the padded 32 byte form
+func (a Alias) Bytes() []byte {
+	return a[:]
+}
+
+// IsText returns true if the alias is printable text followed by padding
+func (a Alias) IsText() bool {
+	t := bytes.TrimRight(a[:], "\x00")
+	if len(t) == 0 || bytes.IndexByte(t, 0) >= 0 || !utf8.Valid(t) {
+		return false
+	}
+	for _, r := range string(t) {
+		if r < 0x20 || r == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// String returns the text without padding if the alias is text, and the
+// base64 form otherwise, e.g. for a VK
+func (a Alias) String() string {
+	if a.IsText() {
+		return string(bytes.TrimRight(a[:], "\x00"))
+	}
+	return ToBase64(a[:])
+}
+
+// LookupAlias resolves a long alias. found is false if the alias is not set.
+func (cl *BW2Client) LookupAlias(name string) (value Alias, found bool, err error) {
+	if _, err := ParseAlias(name); err != nil {
+		return Alias{}, false, err
+	}
+	v, _, err := cl.ResolveLongAlias(name)
+	if err != nil {
+		return Alias{}, false, err
+	}
+	value, err = AliasFromBytes(v)
+	if err != nil {
+		return Alias{}, false, err
+	}
+	return value, !value.IsZero(), nil
+}
+
+// SetAlias creates the long alias name with the given value
+func (cl *BW2Client) SetAlias(account int, name string, value Alias) error {
+	key, err := ParseAlias(name)
+	if err != nil {
+		return err
+	}
+	return cl.CreateLongAlias(account, key.Bytes(), value.Bytes())
+}
+
+// AliasFor returns the "name@" form of a VK or hash, or the empty string if
+// there is no alias for it
+func (cl *BW2Client) AliasFor(key string) (string, error) {
+	bin, err := FromBase64(key)
+	if err != nil {
+		return "", err
+	}
+	s, err := cl.UnresolveAlias(bin)
+	if err != nil {
+		return "", err
+	}
+	s = strings.TrimRight(s, "\x00")
+	if s == "" || s == key {
+		return "", nil
+	}
+	if !strings.HasSuffix(s, "@") {
+		s += "@"
+	}
+	return s, nil
+}
+
+var base64KeyRe = regexp.MustCompile(`[A-Za-z0-9_-]{43}=`)
+
+// AliasFormatter replaces base64 VKs and hashes in text with their aliases,
+// remembering every lookup, including keys without an alias
+type AliasFormatter struct {
+	cl    *BW2Client
+	mu    sync.Mutex
+	names map[string]string
+}
+
+// NewAliasFormatter returns a formatter that looks up aliases through cl
+func (cl *BW2Client) NewAliasFormatter() *AliasFormatter {
+	return &AliasFormatter{cl: cl, names: make(map[string]string)}
+}
+
+// FormatKey returns the "name@" form of the key if it has an alias, and
+// the key otherwise
+func (f *AliasFormatter) FormatKey(key string) string {
+	f.mu.Lock()
+	name, ok := f.names[key]
+	f.mu.Unlock()
+	if !ok {
+		var err error
+		name, err = f.cl.AliasFor(key)
+		if err != nil {
+			// don't remember failures, the router may be back next time
+			return key
+		}
+		f.mu.Lock()
+		f.names[key] = name
+		f.mu.Unlock()
+	}
+	if name == "" {
+		return key
+	}
+	return name
+}
+
+// Format replaces every base64 key in s with its alias
+func (f *AliasFormatter) Format(s string) string {
+	return base64KeyRe.ReplaceAllStringFunc(s, f.FormatKey)
+}
+
+// Sprintf is fmt.Sprintf followed by Format, for decorating log lines
+func (f *AliasFormatter) Sprintf(format string, args ...interface{}) string {
+	return f.Format(fmt.Sprintf(format, args...))
+}
+
+// Dump is like SimpleMessage.Dump but shows aliases instead of keys
+func (f *AliasFormatter) Dump(sm *SimpleMessage) {
+	fmt.Printf("Message from %s on %s:\n", f.FormatKey(sm.From), f.Format(sm.URI))
+	for _, po := range sm.POs {
+		fmt.Println(f.Format(po.TextRepresentation()))
+	}
+}
@@ -0,0 +1,100 @@
+package bw2bind
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseAlias(t *testing.T) {
+	tests := []struct {
+		in   string
+		err  bool
+		text bool
+	}{
+		{"lamp", false, true},
+		{strings.Repeat("x", 32), false, true},
+		{"héllo wörld", false, true},
+		{"", true, false},
+		{strings.Repeat("x", 33), true, false},
+		{"a\x00b", true, false},
+		{"tab\there", false, false},
+	}
+	for _, tt := range tests {
+		a, err := ParseAlias(tt.in)
+		if (err != nil) != tt.err {
+			t.Errorf("%q: error %v", tt.in, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if !bytes.Equal(bytes.TrimRight(a.Bytes(), "\x00"), []byte(tt.in)) || len(a.Bytes()) != 32 {
+			t.Errorf("%q: encoded as %q", tt.in, a.Bytes())
+		}
+		if a.IsText() != tt.text {
+			t.Errorf("%q: IsText is %v", tt.in, a.IsText())
+		}
+		if tt.text && a.String() != tt.in {
+			t.Errorf("%q: String is %q", tt.in, a.String())
+		}
+	}
+}
+
+func TestAliasFromBytes(t *testing.T) {
+	vk := bytes.Repeat([]byte{0xfe}, 32)
+	a, err := AliasFromBytes(vk)
+	if err != nil || a.IsText() || a.String() != ToBase64(vk) {
+		t.Errorf("VK alias is %q, text %v, %v", a.String(), a.IsText(), err)
+	}
+	if a, err := AliasFromBytes(nil); err != nil || !a.IsZero() || a.IsText() {
+		t.Errorf("empty value gave %v, zero %v, %v", a, a.IsZero(), err)
+	}
+	if a, _ := AliasFromBytes([]byte("x")); a.IsZero() {
+		t.Error("a set alias is zero")
+	}
+	if _, err := AliasFromBytes(make([]byte, 33)); err == nil {
+		t.Error("accepted a 33 byte value")
+	}
+}
+
+func TestAliasFormatter(t *testing.T) {
+	named, unnamed, broken := testVK(1), testVK(2), testVK(3)
+	fr, cl := newFakeRouter(t, func(req *frame, send func(*frame)) {
+		bin, _ := req.GetFirstHeaderB("unresolve")
+		switch ToBase64(bin) {
+		case named:
+			send(okay(req, "value", "lamp\x00\x00"))
+		case unnamed:
+			send(okay(req, "value", unnamed))
+		default:
+			send(failed(req, "router unavailable"))
+		}
+	})
+	f := cl.NewAliasFormatter()
+	var got string
+	within(t, 5*time.Second, "Format", func() {
+		got = f.Sprintf("%s granted %s to %s, %s again", named, "a/b", unnamed, named)
+	})
+	want := "lamp@ granted a/b to " + unnamed + ", lamp@ again"
+	if got != want {
+		t.Errorf("formatted as %q, expected %q", got, want)
+	}
+	if n := len(fr.received(cmdResolveAlias)); n != 2 {
+		t.Errorf("looked up %d aliases, expected each key once", n)
+	}
+	within(t, 5*time.Second, "FormatKey", func() {
+		for i := 0; i < 2; i++ {
+			if got := f.FormatKey(broken); got != broken {
+				t.Errorf("failed lookup formatted as %q", got)
+			}
+		}
+	})
+	if n := len(fr.received(cmdResolveAlias)); n != 4 {
+		t.Errorf("failed lookups were remembered, %d lookups in total", n)
+	}
+	if f.Format("not/a/key") != "not/a/key" {
+		t.Error("formatted text without keys")
+	}
+}
@@ -0,0 +1,175 @@
+package bw2bind
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// amountUnits maps unit names to their power of ten in wei
+var amountUnits = map[string]int{
+	"wei":        0,
+	"kwei":       3,
+	"mwei":       6,
+	"gwei":       9,
+	"nanoether":  9,
+	"szabo":      12,
+	"microether": 12,
+	"finney":     15,
+	"milliether": 15,
+	"ether":      18,
+	"kether":     21,
+}
+
+// Amount is an exact quantity of ether, held in wei. Unlike Currency it
+// does not overflow and can represent amounts smaller than a gwei. The
+// zero value is zero wei.
+type Amount struct {
+	wei *big.Int
+}
+
+// AmountFromWei returns an Amount of the given number of wei
+func AmountFromWei(wei *big.Int) Amount {
+	if wei == nil {
+		return Amount{}
+	}
+	return Amount{wei: new(big.Int).Set(wei)}
+}
+
+// AmountFromCurrency converts a Currency to an Amount
+func AmountFromCurrency(c Currency) Amount {
+	return Amount{wei: CurrencyToWei(c)}
+}
+
+// ParseAmount parses an amount such as "1.5 ether", "20gwei" or "0.001
+// Ether". Unit names are not case sensitive. A number without a unit must
+// be a whole number of wei. A decimal point must be followed by digits, so
+// "5." is rejected.
+func ParseAmount(s string) (Amount, error) {
+	s = strings.TrimSpace(s)
+	num := strings.TrimRight(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
+	unit := strings.ToLower(strings.TrimSpace(s[len(num):]))
+	num = strings.TrimSpace(num)
+	if unit == "" {
+		unit = "wei"
+	}
+	exp, ok := amountUnits[unit]
+	if !ok {
+		return Amount{}, fmt.Errorf("amount %q has unknown unit %q", s, unit)
+	}
+	neg := strings.HasPrefix(num, "-")
+	num = strings.TrimPrefix(num, "-")
+	whole, frac := num, ""
+	if i := strings.IndexByte(num, '.'); i >= 0 {
+		whole, frac = num[:i], num[i+1:]
+	}
+	if whole == "" && frac == "" {
+		return Amount{}, fmt.Errorf("amount %q has no number", s)
+	}
+	if strings.HasSuffix(num, ".") {
+		return Amount{}, fmt.Errorf("amount %q has no digits after the decimal point", s)
+	}
+	if len(frac) > exp {
+		return Amount{}, fmt.Errorf("amount %q is more precise than a wei", s)
+	}
+	digits := whole + frac + strings.Repeat("0", exp-len(frac))
+	if strings.Trim(digits, "0123456789") != "" {
+		return Amount{}, fmt.Errorf("amount %q is not a number", s)
+	}
+	wei, ok := new(big.Int).SetString(digits, 10)
+	if !ok {
+		return Amount{}, fmt.Errorf("amount %q is not a number", s)
+	}
+	if neg {
+		wei.Neg(wei)
+	}
+	return Amount{wei: wei}, nil
+}
+
+// Wei returns a copy of the amount in wei
+func (a Amount) Wei() *big.Int {
+	if a.wei == nil {
+		return new(big.Int)
+	}
+	return new(big.Int).Set(a.wei)
+}
+
+// Currency converts the amount to a Currency. It fails if the amount is
+// not a whole number of gwei or does not fit.
+func (a Amount) Currency() (Currency, error) {
+	q, r := new(big.Int).QuoRem(a.Wei(), CurrencyToWei(GigaWei), new(big.Int))
+	if r.Sign() != 0 {
+		return 0, errors.New("amount is not a whole number of gwei")
+	}
+	if !q.IsInt64() {
+		return 0, errors.New("amount is too large for Currency")
+	}
+	return Currency(q.Int64()), nil
+}
+
+// Format renders the amount exactly in the given unit, e.g. "1.5 ether"
+func (a Amount) Format(unit string) (string, error) {
+	exp, ok := amountUnits[strings.ToLower(unit)]
+	if !ok {
+		return "", fmt.Errorf("unknown unit %q", unit)
+	}
+	wei := a.Wei()
+	sign := ""
+	if wei.Sign() < 0 {
+		sign = "-"
+		wei.Neg(wei)
+	}
+	digits := wei.Text(10)
+	if len(digits) <= exp {
+		digits = strings.Repeat("0", exp-len(digits)+1) + digits
+	}
+	whole, frac := digits[:len(digits)-exp], strings.TrimRight(digits[len(digits)-exp:], "0")
+	if frac != "" {
+		whole += "." + frac
+	}
+	return sign + whole + " " + strings.ToLower(unit), nil
+}
+
+// String formats the amount in ether, or in gwei or wei if it is small
+func (a Amount) String() string {
+	abs := new(big.Int).Abs(a.Wei())
+	unit := "ether"
+	switch {
+	case abs.Sign() == 0:
+	case abs.Cmp(big.NewInt(1e9)) < 0:
+		unit = "wei"
+	case abs.Cmp(big.NewInt(1e12)) < 0:
+		unit = "gwei"
+	}
+	s, _ := a.Format(unit)
+	return s
+}
+
+// Add returns a+b
+func (a Amount) Add(b Amount) Amount {
+	return Amount{wei: new(big.Int).Add(a.Wei(), b.Wei())}
+}
+
+// Sub returns a-b
+func (a Amount) Sub(b Amount) Amount {
+	return Amount{wei: new(big.Int).Sub(a.Wei(), b.Wei())}
+}
+
+// Cmp returns -1, 0 or 1 if a is less than, equal to or more than b
+func (a Amount) Cmp(b Amount) int {
+	return a.Wei().Cmp(b.Wei())
+}
+
+// Sign returns -1, 0 or 1 if a is negative, zero or positive
+func (a Amount) Sign() int {
+	return a.Wei().Sign()
+}
+
+// TransferAmount is like TransferWei but takes an Amount
+func (cl *BW2Client) TransferAmount(from int, to string, value Amount) error {
+	if value.Sign() <= 0 {
+		return errors.New("transfer amount must be positive")
+	}
+	return cl.TransferWei(from, to, value.Wei())
+}
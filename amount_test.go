@@ -0,0 +1,91 @@
+package bw2bind
+
+import "testing"
+
+func TestParseAmount(t *testing.T) {
+	cases := []struct {
+		in  string
+		wei string
+		err bool
+	}{
+		{"0", "0", false},
+		{"5", "5", false},
+		{"1.5 ether", "1500000000000000000", false},
+		{"1.5ether", "1500000000000000000", false},
+		{".5 ether", "500000000000000000", false},
+		{"0.001 Ether", "1000000000000000", false},
+		{"20gwei", "20000000000", false},
+		{"20 nanoether", "20000000000", false},
+		{"3 kwei", "3000", false},
+		{"2.5 mwei", "2500000", false},
+		{"1 szabo", "1000000000000", false},
+		{"1 finney", "1000000000000000", false},
+		{"2 kether", "2000000000000000000000", false},
+		{"-1.25 ether", "-1250000000000000000", false},
+		{"  7 WEI  ", "7", false},
+		{"1.5", "", true},
+		{"1.5 wei", "", true},
+		{"0.0000000001 gwei", "", true},
+		{"5.", "", true},
+		{"5. ether", "", true},
+		{".", "", true},
+		{"", "", true},
+		{"ether", "", true},
+		{"1 bitcoin", "", true},
+		{"1e18", "", true},
+		{"1,5 ether", "", true},
+		{"--1", "", true},
+	}
+	for _, c := range cases {
+		a, err := ParseAmount(c.in)
+		if c.err {
+			if err == nil {
+				t.Errorf("ParseAmount(%q) = %s, expected an error", c.in, a.Wei())
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseAmount(%q): %v", c.in, err)
+			continue
+		}
+		if a.Wei().String() != c.wei {
+			t.Errorf("ParseAmount(%q) = %s wei, expected %s", c.in, a.Wei(), c.wei)
+		}
+	}
+}
+
+func TestAmountFormat(t *testing.T) {
+	cases := []struct {
+		in, unit, out string
+	}{
+		{"1.5 ether", "ether", "1.5 ether"},
+		{"1.5 ether", "gwei", "1500000000 gwei"},
+		{"1 wei", "ether", "0.000000000000000001 ether"},
+		{"-0.25 ether", "finney", "-250 finney"},
+		{"0", "ether", "0 ether"},
+		{"20 gwei", "GWEI", "20 gwei"},
+	}
+	for _, c := range cases {
+		a, err := ParseAmount(c.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, err := a.Format(c.unit)
+		if err != nil || s != c.out {
+			t.Errorf("%q in %s = %q, %v; expected %q", c.in, c.unit, s, err, c.out)
+		}
+		if b, err := ParseAmount(s); err != nil || b.Cmp(a) != 0 {
+			t.Errorf("%q does not parse back to %s: %v", s, a.Wei(), err)
+		}
+	}
+	if _, err := (Amount{}).Format("bitcoin"); err == nil {
+		t.Error("expected an error for an unknown unit")
+	}
+	strs := map[string]string{"0": "0 ether", "12": "12 wei", "20 gwei": "20 gwei", "2 ether": "2 ether"}
+	for in, out := range strs {
+		a, _ := ParseAmount(in)
+		if a.String() != out {
+			t.Errorf("%q.String() = %q, expected %q", in, a.String(), out)
+		}
+	}
+}
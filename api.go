@@ -14,6 +14,7 @@ import (
 
 	log "github.com/cihub/seelog"
 	"github.com/immesys/bw2/objects"
+	"github.com/immesys/bw2bind/adps"
 )
 
 // SilenceLog will redirect the log output typically emitted by bw2bind to
@@ -78,7 +79,7 @@ func Connect(to string) (*BW2Client, error) {
 	rv := &BW2Client{c: conn,
 		out:    bufio.NewWriter(conn),
 		in:     bufio.NewReader(conn),
-		seqnos: make(map[int]chan *frame),
+		seqnos: make(map[int]*seqChan),
 		rHost:  to,
 	}
 
@@ -134,12 +135,7 @@ func Connect(to string) (*BW2Client, error) {
 						log.Flush()
 						os.Exit(1)
 					}
-					rv.olock.Lock()
-					dest, ok := rv.seqnos[frame.SeqNo]
-					rv.olock.Unlock()
-					if ok {
-						dest <- frame
-					}
+					rv.deliver(frame)
 				}
 			}()
 			return rv, nil
@@ -201,6 +197,14 @@ func (cl *BW2Client) CreateEntity(p *CreateEntityParams) (string, []byte, error)
 // CreateDOT will create a new Declaration of Trust and return the
 // DOT Hash and the binary representation
 func (cl *BW2Client) CreateDOT(p *CreateDOTParams) (string, []byte, error) {
+	if !p.IsPermission {
+		if err := adps.Validate(p.AccessPermissions); err != nil {
+			return "", nil, err
+		}
+		if err := adps.ValidatePattern(p.URI); err != nil {
+			return "", nil, err
+		}
+	}
 	seqno := cl.GetSeqNo()
 	req := createFrame(cmdMakeDot, seqno)
 	if p.Expiry != nil {
@@ -346,6 +350,13 @@ func (cl *BW2Client) Subscribe(p *SubscribeParams) (chan *SimpleMessage, error)
 // channel that received messages will be written to, a handle that can be
 // passed to unsubscribe, and an error
 func (cl *BW2Client) SubscribeH(p *SubscribeParams) (chan *SimpleMessage, string, error) {
+	rv, handle, _, err := cl.subscribe(p)
+	return rv, handle, err
+}
+
+// subscribe is SubscribeH that also returns the sequence number, which
+// closeSeqno takes to close the returned channel
+func (cl *BW2Client) subscribe(p *SubscribeParams) (chan *SimpleMessage, string, int, error) {
 	seqno := cl.GetSeqNo()
 	req := createFrame(cmdSubscribe, seqno)
 	if cl.defAutoChain != nil {
@@ -381,7 +392,7 @@ func (cl *BW2Client) SubscribeH(p *SubscribeParams) (chan *SimpleMessage, string
 	err := fr.MustResponse()
 
 	if err != nil {
-		return nil, "", err
+		return nil, "", 0, err
 	}
 	handle, _ := fr.GetFirstHeader("handle")
 	//Generate converted output channel
@@ -414,7 +425,7 @@ func (cl *BW2Client) SubscribeH(p *SubscribeParams) (chan *SimpleMessage, string
 		}
 		close(rv)
 	}()
-	return rv, handle, nil
+	return rv, handle, seqno, nil
 }
 
 // SetEntity will tell your local router "who you are". This is the
@@ -486,6 +497,12 @@ func (cl *BW2Client) SetEntityFile(filename string) (vk string, err error) {
 // to the given VK. It returns a channel that the chains will be written to.
 // This is a poweruser method, consider using BuildAnyChain or simply AutoChain
 func (cl *BW2Client) BuildChain(uri, permissions, to string) (chan *SimpleChain, error) {
+	if err := adps.Validate(permissions); err != nil {
+		return nil, err
+	}
+	if err := adps.ValidatePattern(uri); err != nil {
+		return nil, err
+	}
 	seqno := cl.GetSeqNo()
 	req := createFrame(cmdBuildChain, seqno)
 	req.AddHeader("uri", uri)
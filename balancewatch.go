@@ -0,0 +1,220 @@
+package bw2bind
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+)
+
+// DefaultBalanceInterval is how often a BalanceWatcher polls balances
+const DefaultBalanceInterval = time.Minute
+
+// BalanceEvent describes a change in the balance of one of the router's
+// accounts
+type BalanceEvent struct {
+	Account  int
+	Addr     string
+	Previous Amount
+	Current  Amount
+	// Current - Previous
+	Delta Amount
+	// The balance is below the watcher's threshold
+	Low bool
+}
+
+// BalanceWatcherParams configures a BalanceWatcher
+type BalanceWatcherParams struct {
+	// How often to poll, DefaultBalanceInterval if zero
+	Interval time.Duration
+	// Accounts with less than this are low. Zero disables alerts and top ups
+	Threshold Amount
+	// Called when an account becomes low
+	OnLow func(ev *BalanceEvent)
+	// Top up low accounts from the funding account
+	TopUp bool
+	// The account to top up from
+	FundingAccount int
+	// Top up to this balance, or to twice the threshold if zero
+	TopUpTo Amount
+	// The most that may be transferred in total by top ups
+	Budget Amount
+	// Called after a top up has been sent
+	OnTopUp func(account int, addr string, value Amount)
+}
+
+// BalanceWatcher polls the balances of the router's accounts and emits an
+// event for every change. The first poll emits an event for every account.
+type BalanceWatcher struct {
+	cl       *BW2Client
+	p        BalanceWatcherParams
+	mu       sync.Mutex
+	balances []*BalanceInfo
+	spent    Amount
+	topped   map[int]bool
+	events   chan *BalanceEvent
+	stop     chan struct{}
+	stopOnce sync.Once
+	check    sync.Mutex
+	closed   bool
+}
+
+// WatchBalances starts a watcher for the balances of the router's accounts
+func (cl *BW2Client) WatchBalances(p *BalanceWatcherParams) *BalanceWatcher {
+	rv := &BalanceWatcher{
+		cl:     cl,
+		p:      *p,
+		topped: make(map[int]bool),
+		events: make(chan *BalanceEvent, 10),
+		stop:   make(chan struct{}),
+	}
+	if rv.p.Interval == 0 {
+		rv.p.Interval = DefaultBalanceInterval
+	}
+	if rv.p.TopUpTo.Sign() == 0 {
+		rv.p.TopUpTo = rv.p.Threshold.Add(rv.p.Threshold)
+	}
+	go rv.loop()
+	return rv
+}
+
+// Events returns the channel that balance events are written to. It is
+// closed when the watcher is stopped.
+func (bw *BalanceWatcher) Events() <-chan *BalanceEvent {
+	return bw.events
+}
+
+// Balances returns the balances from the last poll
+func (bw *BalanceWatcher) Balances() []*BalanceInfo {
+	bw.mu.Lock()
+	defer bw.mu.Unlock()
+	return bw.balances
+}
+
+// Spent returns the total transferred by top ups so far
+func (bw *BalanceWatcher) Spent() Amount {
+	bw.mu.Lock()
+	defer bw.mu.Unlock()
+	return bw.spent
+}
+
+// Stop stops the watcher and closes the event channel
+func (bw *BalanceWatcher) Stop() {
+	bw.stopOnce.Do(func() { close(bw.stop) })
+}
+
+func (bw *BalanceWatcher) loop() {
+	defer func() {
+		bw.check.Lock()
+		bw.closed = true
+		close(bw.events)
+		bw.check.Unlock()
+	}()
+	tick := time.NewTicker(bw.p.Interval)
+	defer tick.Stop()
+	for {
+		if !bw.Check() {
+			return
+		}
+		select {
+		case <-bw.stop:
+			return
+		case <-tick.C:
+		}
+	}
+}
+
+func (bw *BalanceWatcher) isLow(a Amount) bool {
+	return bw.p.Threshold.Sign() > 0 && a.Cmp(bw.p.Threshold) < 0
+}
+
+// Check polls the balances now, emits events for those that changed and
+// tops up low accounts. It returns false if the watcher was stopped.
+func (bw *BalanceWatcher) Check() bool {
+	bw.check.Lock()
+	defer bw.check.Unlock()
+	if bw.closed {
+		return false
+	}
+	cur, err := bw.cl.EntityBalances()
+	if err != nil {
+		log.Warn("could not get balances: ", err)
+		return true
+	}
+	bw.mu.Lock()
+	prev := bw.balances
+	bw.balances = cur
+	bw.mu.Unlock()
+	for i, bi := range cur {
+		ev := &BalanceEvent{Account: i, Addr: bi.Addr, Current: bi.Amount()}
+		first := i >= len(prev) || prev[i].Addr != bi.Addr
+		if !first {
+			ev.Previous = prev[i].Amount()
+		}
+		ev.Delta = ev.Current.Sub(ev.Previous)
+		ev.Low = bw.isLow(ev.Current)
+		if ev.Low && (first || !bw.isLow(ev.Previous)) {
+			log.Warnf("account %d (%s) is low: %s", i, bi.Addr, ev.Current)
+			if bw.p.OnLow != nil {
+				bw.p.OnLow(ev)
+			}
+		}
+		// an account is topped up once per dip below the threshold, so
+		// that it is not topped up again while the transfer is mined
+		if !ev.Low {
+			delete(bw.topped, i)
+		} else if bw.p.TopUp && i != bw.p.FundingAccount && !bw.topped[i] {
+			bw.topped[i] = bw.topUp(i, bi.Addr, ev.Current)
+		}
+		if !first && ev.Delta.Sign() == 0 {
+			continue
+		}
+		select {
+		case bw.events <- ev:
+		case <-bw.stop:
+			return false
+		}
+	}
+	return true
+}
+
+// topUpAmount returns how much to send to bring an account with the given
+// balance up to TopUpTo, capped at the budget that is left, and whether it
+// was capped
+func (bw *BalanceWatcher) topUpAmount(have Amount) (want Amount, left Amount, capped bool) {
+	want = bw.p.TopUpTo.Sub(have)
+	bw.mu.Lock()
+	left = bw.p.Budget.Sub(bw.spent)
+	bw.mu.Unlock()
+	if want.Cmp(left) > 0 {
+		return left, left, true
+	}
+	return want, left, false
+}
+
+// topUp brings the account up to TopUpTo, within the remaining budget,
+// and returns true if a transfer was sent
+func (bw *BalanceWatcher) topUp(account int, addr string, have Amount) bool {
+	want, left, capped := bw.topUpAmount(have)
+	if want.Sign() <= 0 {
+		if capped {
+			log.Warnf("not topping up account %d (%s): budget of %s is used up", account, addr, bw.p.Budget)
+		}
+		return false
+	}
+	if capped {
+		log.Warnf("top up of account %d (%s) capped at %s, all that is left of the budget of %s", account, addr, want, bw.p.Budget)
+	}
+	if err := bw.cl.TransferAmount(bw.p.FundingAccount, addr, want); err != nil {
+		log.Warnf("could not top up account %d (%s): %v", account, addr, err)
+		return false
+	}
+	bw.mu.Lock()
+	bw.spent = bw.spent.Add(want)
+	bw.mu.Unlock()
+	log.Infof("topped up account %d (%s) with %s from account %d, %s of the budget left", account, addr, want, bw.p.FundingAccount, left.Sub(want))
+	if bw.p.OnTopUp != nil {
+		bw.p.OnTopUp(account, addr, want)
+	}
+	return true
+}
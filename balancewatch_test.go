@@ -0,0 +1,33 @@
+package bw2bind
+
+import "testing"
+
+func TestBalanceTopUpAmount(t *testing.T) {
+	ether := func(s string) Amount {
+		a, err := ParseAmount(s + " ether")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return a
+	}
+	bw := &BalanceWatcher{p: BalanceWatcherParams{TopUpTo: ether("10"), Budget: ether("15")}}
+	cases := []struct {
+		have, spent string
+		want, left  string
+		capped      bool
+	}{
+		{"4", "0", "6", "15", false},
+		{"4", "12", "3", "3", true},
+		{"9.5", "14", "0.5", "1", false},
+		{"1", "15", "0", "0", true},
+		{"10", "0", "0", "15", false},
+	}
+	for _, c := range cases {
+		bw.spent = ether(c.spent)
+		want, left, capped := bw.topUpAmount(ether(c.have))
+		if want.Cmp(ether(c.want)) != 0 || left.Cmp(ether(c.left)) != 0 || capped != c.capped {
+			t.Errorf("have %s, spent %s: got %s, %s left, capped %v; expected %s ether, %s left, capped %v",
+				c.have, c.spent, want, left, capped, c.want, c.left, c.capped)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package bw2bind
+
+import (
+	"sync"
+
+	"github.com/immesys/bw2/objects"
+)
+
+// DefaultBatchConcurrency is the number of requests a batch call keeps in
+// flight if it is given a concurrency of zero or less
+const DefaultBatchConcurrency = 16
+
+// RegistryResult is one result of ResolveRegistryMany
+type RegistryResult struct {
+	Key      string
+	RO       objects.RoutingObject
+	Validity RegistryValidity
+	Err      error
+}
+
+// AliasResult is one result of ResolveAliasesMany
+type AliasResult struct {
+	Alias string
+	Value []byte
+	Zero  bool
+	Err   error
+}
+
+// DOTsResult is one result of FindDOTsFromVKs
+type DOTsResult struct {
+	VK       string
+	DOTs     []*objects.DOT
+	Validity []RegistryValidity
+	Err      error
+}
+
+// batch calls fn for 0..n-1 with at most concurrency calls running at once
+func batch(n int, concurrency int, fn func(i int)) {
+	if concurrency <= 0 {
+		concurrency = DefaultBatchConcurrency
+	}
+	sem := make(chan struct{}, concurrency)
+	wg := sync.WaitGroup{}
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		sem <- struct{}{}
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			fn(i)
+		}(i)
+	}
+	wg.Wait()
+}
+
+// ResolveRegistryMany resolves many VKs or DOT hashes concurrently, with
+// at most concurrency requests in flight. The results are in the same
+// order as the keys and a failed key does not affect the others.
+func (cl *BW2Client) ResolveRegistryMany(keys []string, concurrency int) []*RegistryResult {
+	rv := make([]*RegistryResult, len(keys))
+	batch(len(keys), concurrency, func(i int) {
+		ro, v, err := cl.ResolveRegistry(keys[i])
+		rv[i] = &RegistryResult{Key: keys[i], RO: ro, Validity: v, Err: err}
+	})
+	return rv
+}
+
+// ResolveAliasesMany resolves many long aliases concurrently, like
+// ResolveRegistryMany
+func (cl *BW2Client) ResolveAliasesMany(aliases []string, concurrency int) []*AliasResult {
+	rv := make([]*AliasResult, len(aliases))
+	batch(len(aliases), concurrency, func(i int) {
+		v, zero, err := cl.ResolveLongAlias(aliases[i])
+		rv[i] = &AliasResult{Alias: aliases[i], Value: v, Zero: zero, Err: err}
+	})
+	return rv
+}
+
+// FindDOTsFromVKs finds the DOTs granted from many VKs concurrently, like
+// ResolveRegistryMany
+func (cl *BW2Client) FindDOTsFromVKs(vks []string, concurrency int) []*DOTsResult {
+	rv := make([]*DOTsResult, len(vks))
+	batch(len(vks), concurrency, func(i int) {
+		dots, v, err := cl.FindDOTsFromVK(vks[i])
+		rv[i] = &DOTsResult{VK: vks[i], DOTs: dots, Validity: v, Err: err}
+	})
+	return rv
+}
@@ -0,0 +1,75 @@
+package bw2bind
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBatch(t *testing.T) {
+	tests := []struct {
+		n, concurrency, bound int
+	}{
+		{0, 4, 4},
+		{1, 4, 4},
+		{20, 1, 1},
+		{20, 3, 3},
+		{40, 0, DefaultBatchConcurrency},
+		{40, -1, DefaultBatchConcurrency},
+	}
+	for _, tt := range tests {
+		var mu sync.Mutex
+		calls := make([]int, tt.n)
+		var running, peak int32
+		batch(tt.n, tt.concurrency, func(i int) {
+			r := atomic.AddInt32(&running, 1)
+			mu.Lock()
+			calls[i]++
+			if r > peak {
+				peak = r
+			}
+			mu.Unlock()
+			time.Sleep(time.Millisecond)
+			atomic.AddInt32(&running, -1)
+		})
+		for i, c := range calls {
+			if c != 1 {
+				t.Errorf("n=%d concurrency=%d: index %d called %d times", tt.n, tt.concurrency, i, c)
+			}
+		}
+		if int(peak) > tt.bound {
+			t.Errorf("n=%d concurrency=%d: %d calls ran at once, bound is %d", tt.n, tt.concurrency, peak, tt.bound)
+		}
+	}
+}
+
+func TestResolveRegistryManyOrder(t *testing.T) {
+	_, cl := newFakeRouter(t, func(req *frame, send func(*frame)) {
+		key, _ := req.GetFirstHeader("key")
+		if key == "bad" {
+			send(failed(req, "no such key"))
+			return
+		}
+		send(okay(req))
+	})
+	keys := []string{"a", "bad", "b", "c", "bad", "d"}
+	var res []*RegistryResult
+	within(t, 5*time.Second, "ResolveRegistryMany", func() {
+		res = cl.ResolveRegistryMany(keys, 2)
+	})
+	if len(res) != len(keys) {
+		t.Fatalf("got %d results for %d keys", len(res), len(keys))
+	}
+	for i, r := range res {
+		if r.Key != keys[i] {
+			t.Errorf("result %d is for %s, expected %s", i, r.Key, keys[i])
+		}
+		if (r.Err != nil) != (keys[i] == "bad") {
+			t.Errorf("result for %s has error %v", r.Key, r.Err)
+		}
+		if r.Err == nil && r.Validity != StateUnknown {
+			t.Errorf("result for %s has validity %d", r.Key, r.Validity)
+		}
+	}
+}
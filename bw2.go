@@ -13,11 +13,20 @@ type BW2Client struct {
 	out          *bufio.Writer
 	in           *bufio.Reader
 	remotever    string
-	seqnos       map[int]chan *frame
+	seqnos       map[int]*seqChan
 	olock        sync.Mutex
 	curseqno     uint32
 	defAutoChain *bool
 	rHost        string
+	ks           *Keystore
+}
+
+// seqChan carries the response frames for one sequence number. done is
+// closed when the sequence number is finished or cancelled, after which
+// no more frames are delivered.
+type seqChan struct {
+	ch   chan *frame
+	done chan struct{}
 }
 
 func (cl *BW2Client) Close() error {
@@ -28,23 +37,28 @@ func (cl *BW2Client) Close() error {
 //Automatically closes the returned channel when there are no more responses.
 func (cl *BW2Client) transact(req *frame) chan *frame {
 	seqno := req.SeqNo
-	inchan := make(chan *frame, 3)
+	sc := &seqChan{ch: make(chan *frame, 3), done: make(chan struct{})}
 	outchan := make(chan *frame, 3)
 	cl.olock.Lock()
-	cl.seqnos[seqno] = inchan
+	cl.seqnos[seqno] = sc
 	req.WriteToStream(cl.out)
 	cl.olock.Unlock()
 	go func() {
+		defer close(outchan)
 		for {
-			fr, ok := <-inchan
-			if !ok {
-				close(outchan)
+			var fr *frame
+			select {
+			case fr = <-sc.ch:
+			case <-sc.done:
+				return
+			}
+			select {
+			case outchan <- fr:
+			case <-sc.done:
 				return
 			}
-			outchan <- fr
 			finished, ok := fr.GetFirstHeader("finished")
 			if ok && finished == "true" {
-				close(outchan)
 				cl.closeSeqno(fr.SeqNo)
 				return
 			}
@@ -52,12 +66,29 @@ func (cl *BW2Client) transact(req *frame) chan *frame {
 	}()
 	return outchan
 }
+
+// closeSeqno stops delivering frames for the given sequence number and
+// closes the channel returned by transact. It is safe to call more than once.
 func (cl *BW2Client) closeSeqno(seqno int) {
 	cl.olock.Lock()
-	ch, ok := cl.seqnos[seqno]
+	sc, ok := cl.seqnos[seqno]
 	if ok {
-		close(ch)
+		close(sc.done)
 		delete(cl.seqnos, seqno)
 	}
 	cl.olock.Unlock()
 }
+
+// deliver passes a frame read from the router to the transaction waiting
+// for it, dropping it if there is none
+func (cl *BW2Client) deliver(fr *frame) {
+	cl.olock.Lock()
+	sc, ok := cl.seqnos[fr.SeqNo]
+	cl.olock.Unlock()
+	if ok {
+		select {
+		case sc.ch <- fr:
+		case <-sc.done:
+		}
+	}
+}
@@ -256,10 +256,21 @@ func (sm *SimpleMessage) GetOnePODF(df string) PayloadObject {
 }
 
 type View struct {
-	vid  int
-	cl   *BW2Client
-	cbz  []func()
-	cbmu sync.Mutex
+	vid    int
+	cl     *BW2Client
+	cbz    []func()
+	cbmu   sync.Mutex
+	seqno  int
+	handle string
+	subs   []viewSub
+	closed bool
+	done   chan struct{}
+}
+
+// viewSub is a SubSlot or SubSignal subscription made through a view
+type viewSub struct {
+	seqno  int
+	handle string
 }
 
 type InterfaceDescriptor struct {
@@ -0,0 +1,104 @@
+package bw2bind
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrUnboundDescriptor is returned by InterfaceDescriptor methods on
+// descriptors that were not obtained from View.List
+var ErrUnboundDescriptor = errors.New("interface descriptor is not bound to a view")
+
+// View returns the view this descriptor was listed from, or nil
+func (ifd *InterfaceDescriptor) View() *View {
+	return ifd.v
+}
+
+func (ifd *InterfaceDescriptor) SignalURI(signal string) string {
+	return ifd.URI + "/signal/" + signal
+}
+
+func (ifd *InterfaceDescriptor) SlotURI(slot string) string {
+	return ifd.URI + "/slot/" + slot
+}
+
+// PublishSlot publishes the payload objects to the given slot of this
+// interface
+func (ifd *InterfaceDescriptor) PublishSlot(slot string, poz ...PayloadObject) error {
+	if ifd.v == nil {
+		return ErrUnboundDescriptor
+	}
+	if ifd.v.isClosed() {
+		return ErrViewClosed
+	}
+	return ifd.v.cl.Publish(&PublishParams{
+		URI:            ifd.SlotURI(slot),
+		AutoChain:      true,
+		PayloadObjects: poz,
+	})
+}
+
+// SubscribeSignal subscribes to the given signal of this interface and
+// returns the subscription handle. The subscription is cancelled by
+// Unsubscribe or when the view is closed.
+func (ifd *InterfaceDescriptor) SubscribeSignal(signal string, cb func(*SimpleMessage)) (string, error) {
+	if ifd.v == nil {
+		return "", ErrUnboundDescriptor
+	}
+	if ifd.v.isClosed() {
+		return "", ErrViewClosed
+	}
+	rc, handle, seqno, err := ifd.v.cl.subscribe(&SubscribeParams{
+		URI:       ifd.SignalURI(signal),
+		AutoChain: true,
+	})
+	if err != nil {
+		return "", err
+	}
+	ifd.v.cbmu.Lock()
+	if ifd.v.closed {
+		ifd.v.cbmu.Unlock()
+		ifd.v.cl.Unsubscribe(handle)
+		ifd.v.cl.closeSeqno(seqno)
+		return "", ErrViewClosed
+	}
+	ifd.v.subs = append(ifd.v.subs, viewSub{seqno: seqno, handle: handle})
+	ifd.v.cbmu.Unlock()
+	chToCB(rc, cb)
+	return handle, nil
+}
+
+// Unsubscribe cancels a subscription made with SubscribeSignal, after
+// which its callback is no longer called
+func (ifd *InterfaceDescriptor) Unsubscribe(handle string) error {
+	if ifd.v == nil {
+		return ErrUnboundDescriptor
+	}
+	return ifd.v.unsubscribe(handle)
+}
+
+// GetMetadata fetches the current metadata of the interface from the
+// router, rather than the copy taken when the view was listed
+func (ifd *InterfaceDescriptor) GetMetadata() (map[string]*MetadataTuple, error) {
+	if ifd.v == nil {
+		return nil, ErrUnboundDescriptor
+	}
+	md, _, err := ifd.v.cl.GetMetadata(ifd.URI)
+	return md, err
+}
+
+// LastAlive returns the time of the last heartbeat of the interface, or
+// the zero time if it has never published one
+func (ifd *InterfaceDescriptor) LastAlive() (time.Time, error) {
+	if ifd.v == nil {
+		return time.Time{}, ErrUnboundDescriptor
+	}
+	return ifd.v.cl.lastAlive(ifd.URI)
+}
+
+// IsAlive returns true if the interface has published a heartbeat within
+// DefaultStaleAfter
+func (ifd *InterfaceDescriptor) IsAlive() (bool, error) {
+	t, err := ifd.LastAlive()
+	return err == nil && time.Now().Sub(t) <= DefaultStaleAfter, err
+}
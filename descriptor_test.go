@@ -0,0 +1,130 @@
+package bw2bind
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/immesys/bw2bind/expr"
+)
+
+// handleRouter is a view router that rejects unsubscribing a handle that
+// is not subscribed, like the real router
+type handleRouter struct {
+	mu      sync.Mutex
+	active  map[string]*frame
+	views   chan *frame
+	handler func(fr *frame, send func(*frame))
+}
+
+func newHandleRouter() *handleRouter {
+	hr := &handleRouter{active: make(map[string]*frame), views: make(chan *frame, 1)}
+	hr.handler = func(fr *frame, send func(*frame)) {
+		hr.mu.Lock()
+		defer hr.mu.Unlock()
+		h := fr.Cmd + strconv.Itoa(fr.SeqNo)
+		switch fr.Cmd {
+		case cmdMakeView:
+			hr.active[h] = fr
+			send(okay(fr, "handle", h, "id", "7"))
+			hr.views <- fr
+		case cmdSubscribe, cmdSubscribeView:
+			hr.active[h] = fr
+			send(okay(fr, "handle", h))
+		case cmdUnsubscribe:
+			uh, _ := fr.GetFirstHeader("handle")
+			if _, ok := hr.active[uh]; !ok {
+				rsp := createFrame(cmdResponse, fr.SeqNo)
+				rsp.AddHeader("status", "error")
+				rsp.AddHeader("reason", "no such handle")
+				send(rsp)
+				return
+			}
+			delete(hr.active, uh)
+			send(okay(fr))
+		}
+	}
+	return hr
+}
+
+// subscription returns the request for an active handle
+func (hr *handleRouter) subscription(h string) *frame {
+	hr.mu.Lock()
+	defer hr.mu.Unlock()
+	return hr.active[h]
+}
+
+func TestDescriptorSubscribeSignal(t *testing.T) {
+	hr := newHandleRouter()
+	router, cl := newFakeRouter(t, hr.handler)
+	v, err := cl.CreateView(expr.M{"uri": "ns/*"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-hr.views
+	ifd := &InterfaceDescriptor{URI: "ns/svc/dev/i.test", v: v}
+	got := make(chan string, 10)
+	subscribe := func() (string, *frame) {
+		h, err := ifd.SubscribeSignal("sig", func(sm *SimpleMessage) { got <- sm.URI })
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := hr.subscription(h)
+		if uri, _ := req.GetFirstHeader("uri"); uri != ifd.SignalURI("sig") {
+			t.Fatalf("subscribed to %q", uri)
+		}
+		return h, req
+	}
+	expect := func(delivered bool) {
+		select {
+		case <-got:
+			if !delivered {
+				t.Fatal("message delivered after unsubscribing")
+			}
+		case <-time.After(100 * time.Millisecond):
+			if delivered {
+				t.Fatal("message not delivered")
+			}
+		}
+	}
+
+	h1, req1 := subscribe()
+	router.send(result(req1, "uri", "ns/svc/dev/i.test/signal/sig"))
+	expect(true)
+	if err := ifd.Unsubscribe(h1); err != nil {
+		t.Fatal(err)
+	}
+	router.send(result(req1, "uri", "ns/svc/dev/i.test/signal/sig"))
+	expect(false)
+	if err := ifd.Unsubscribe(h1); err == nil {
+		t.Error("expected an error unsubscribing twice")
+	}
+
+	// a subscription cancelled behind the view's back does not make Close
+	// fail, and the rest are still closed
+	h2, _ := subscribe()
+	_, req3 := subscribe()
+	if err := cl.Unsubscribe(h2); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Close(); err != nil {
+		t.Fatalf("Close after a manual Unsubscribe: %v", err)
+	}
+	router.send(result(req3, "uri", "ns/svc/dev/i.test/signal/sig"))
+	expect(false)
+	hr.mu.Lock()
+	if len(hr.active) != 0 {
+		t.Errorf("handles left on the router: %v", hr.active)
+	}
+	hr.mu.Unlock()
+	if err := ifd.Unsubscribe(h1); err != ErrViewClosed {
+		t.Errorf("Unsubscribe after Close gave %v", err)
+	}
+	if _, err := ifd.SubscribeSignal("sig", func(*SimpleMessage) {}); err != ErrViewClosed {
+		t.Errorf("SubscribeSignal after Close gave %v", err)
+	}
+	if _, err := (&InterfaceDescriptor{}).SubscribeSignal("sig", nil); err != ErrUnboundDescriptor {
+		t.Errorf("SubscribeSignal on an unbound descriptor gave %v", err)
+	}
+}
@@ -0,0 +1,346 @@
+package bw2bind
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultStaleAfter is how long a service or interface may go without a
+// heartbeat before discovery considers it stale
+const DefaultStaleAfter = 3 * RegistrationInterval * time.Second
+
+const lastAliveSuffix = "/!meta/lastalive"
+
+// DiscoveredService describes a service found below a base URI by its
+// lastalive heartbeat
+type DiscoveredService struct {
+	// The full URI of the service, e.g. ns/base/s.name
+	URI string
+	// The last segment of URI
+	Name      string
+	LastAlive time.Time
+	// True if the last heartbeat is more recent than the stale threshold
+	Alive      bool
+	Interfaces []*DiscoveredInterface
+}
+
+// DiscoveredInterface describes an interface found below a discovered service
+type DiscoveredInterface struct {
+	// The full URI of the interface, e.g. ns/base/s.name/prefix/i.name
+	URI string
+	// The full URI of the owning service
+	Service   string
+	Prefix    string
+	Name      string
+	LastAlive time.Time
+	Alive     bool
+}
+
+// DiscoveryParams configures DiscoverServices and WatchServices
+type DiscoveryParams struct {
+	// The URI to discover services below
+	BaseURI string
+	// How long without a heartbeat until a service is stale, defaults
+	// to DefaultStaleAfter
+	StaleAfter time.Duration
+	// How often WatchServices requeries the router to notice heartbeats
+	// lapsing or being deleted, defaults to RegistrationInterval
+	PollInterval time.Duration
+}
+
+type DiscoveryEventType int
+
+const (
+	// A service or interface was seen for the first time
+	DiscoveryAdded DiscoveryEventType = iota
+	// A service or interface no longer has a heartbeat on the router
+	DiscoveryRemoved
+	// A service or interface became alive or stale
+	DiscoveryChanged
+)
+
+func (t DiscoveryEventType) String() string {
+	switch t {
+	case DiscoveryAdded:
+		return "added"
+	case DiscoveryRemoved:
+		return "removed"
+	case DiscoveryChanged:
+		return "changed"
+	}
+	return "unknown"
+}
+
+// DiscoveryEvent is emitted by a ServiceWatcher. Interface is nil if the
+// event concerns the service itself.
+type DiscoveryEvent struct {
+	Type      DiscoveryEventType
+	Service   *DiscoveredService
+	Interface *DiscoveredInterface
+}
+
+// ServiceWatcher tracks the services below a base URI, see WatchServices
+type ServiceWatcher struct {
+	cl       *BW2Client
+	p        DiscoveryParams
+	handle   string
+	events   chan *DiscoveryEvent
+	stop     chan struct{}
+	stopOnce sync.Once
+	mu       sync.Mutex
+	beats    map[string]time.Time
+	known    map[string]*DiscoveryEvent
+}
+
+func (p *DiscoveryParams) normalize() {
+	p.BaseURI = strings.TrimSuffix(p.BaseURI, "/")
+	if p.StaleAfter == 0 {
+		p.StaleAfter = DefaultStaleAfter
+	}
+	if p.PollInterval == 0 {
+		p.PollInterval = RegistrationInterval * time.Second
+	}
+}
+
+// DiscoverServices lists all the services and interfaces below the given
+// base URI that have ever published a heartbeat, classifying each as alive
+// or stale
+func (cl *BW2Client) DiscoverServices(p *DiscoveryParams) ([]*DiscoveredService, error) {
+	np := *p
+	np.normalize()
+	beats, err := cl.queryHeartbeats(np.BaseURI)
+	if err != nil {
+		return nil, err
+	}
+	return buildServiceTree(beats, np.StaleAfter, time.Now()), nil
+}
+
+func (cl *BW2Client) queryHeartbeats(baseuri string) (map[string]time.Time, error) {
+	smc, err := cl.Query(&QueryParams{
+		URI:       baseuri + "/*" + lastAliveSuffix,
+		AutoChain: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	rv := make(map[string]time.Time)
+	for sm := range smc {
+		if uri, t, ok := parseHeartbeat(sm); ok {
+			rv[uri] = t
+		}
+	}
+	return rv, nil
+}
+
+func parseHeartbeat(sm *SimpleMessage) (string, time.Time, bool) {
+	if !strings.HasSuffix(sm.URI, lastAliveSuffix) {
+		return "", time.Time{}, false
+	}
+	meta, ok := sm.GetOnePODF(PODFSMetadata).(MetadataPayloadObject)
+	if !ok {
+		return "", time.Time{}, false
+	}
+	return strings.TrimSuffix(sm.URI, lastAliveSuffix), meta.Value().Time(), true
+}
+
+// buildServiceTree treats every heartbeat URI without a heartbeat ancestor
+// as a service, and every other heartbeat URI as an interface of its
+// nearest service ancestor
+func buildServiceTree(beats map[string]time.Time, staleAfter time.Duration, now time.Time) []*DiscoveredService {
+	uris := make([]string, 0, len(beats))
+	for uri := range beats {
+		uris = append(uris, uri)
+	}
+	sort.Slice(uris, func(i, j int) bool {
+		ci, cj := strings.Count(uris[i], "/"), strings.Count(uris[j], "/")
+		if ci != cj {
+			return ci < cj
+		}
+		return uris[i] < uris[j]
+	})
+	svcs := make(map[string]*DiscoveredService)
+	rv := []*DiscoveredService{}
+	for _, uri := range uris {
+		last := beats[uri]
+		alive := now.Sub(last) <= staleAfter
+		parts := strings.Split(uri, "/")
+		var parent *DiscoveredService
+		for i := len(parts) - 1; i > 0 && parent == nil; i-- {
+			parent = svcs[strings.Join(parts[:i], "/")]
+		}
+		if parent == nil {
+			s := &DiscoveredService{
+				URI:       uri,
+				Name:      parts[len(parts)-1],
+				LastAlive: last,
+				Alive:     alive,
+			}
+			svcs[uri] = s
+			rv = append(rv, s)
+			continue
+		}
+		rel := strings.TrimPrefix(uri, parent.URI+"/")
+		prefix := ""
+		if idx := strings.LastIndex(rel, "/"); idx >= 0 {
+			prefix = rel[:idx]
+		}
+		parent.Interfaces = append(parent.Interfaces, &DiscoveredInterface{
+			URI:       uri,
+			Service:   parent.URI,
+			Prefix:    prefix,
+			Name:      parts[len(parts)-1],
+			LastAlive: last,
+			Alive:     alive,
+		})
+	}
+	return rv
+}
+
+// WatchServices discovers the services below a base URI and then emits
+// events as heartbeats appear, lapse or are removed. The initial set of
+// services is delivered as DiscoveryAdded events.
+func (cl *BW2Client) WatchServices(p *DiscoveryParams) (*ServiceWatcher, error) {
+	np := *p
+	np.normalize()
+	rv := &ServiceWatcher{
+		cl:     cl,
+		p:      np,
+		events: make(chan *DiscoveryEvent, 10),
+		stop:   make(chan struct{}),
+		known:  make(map[string]*DiscoveryEvent),
+	}
+	beats, err := cl.queryHeartbeats(np.BaseURI)
+	if err != nil {
+		return nil, err
+	}
+	subc, handle, err := cl.SubscribeH(&SubscribeParams{
+		URI:       np.BaseURI + "/*" + lastAliveSuffix,
+		AutoChain: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	rv.handle = handle
+	rv.beats = beats
+	go rv.loop(subc)
+	return rv, nil
+}
+
+// Events returns the channel that discovery events are written to. It is
+// closed when the watcher is stopped.
+func (sw *ServiceWatcher) Events() <-chan *DiscoveryEvent {
+	return sw.events
+}
+
+// Services returns the current view of the discovered services
+func (sw *ServiceWatcher) Services() []*DiscoveredService {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	return buildServiceTree(sw.beats, sw.p.StaleAfter, time.Now())
+}
+
+// Stop unsubscribes from heartbeats and closes the event channel
+func (sw *ServiceWatcher) Stop() error {
+	var err error
+	sw.stopOnce.Do(func() {
+		close(sw.stop)
+		err = sw.cl.Unsubscribe(sw.handle)
+	})
+	return err
+}
+
+func (sw *ServiceWatcher) loop(subc chan *SimpleMessage) {
+	defer close(sw.events)
+	defer func() {
+		go func() {
+			for _ = range subc {
+			}
+		}()
+	}()
+	tick := time.NewTicker(sw.p.PollInterval)
+	defer tick.Stop()
+	sw.emit()
+	for {
+		select {
+		case <-sw.stop:
+			return
+		case sm, ok := <-subc:
+			if !ok {
+				return
+			}
+			uri, t, ok := parseHeartbeat(sm)
+			if !ok {
+				continue
+			}
+			sw.mu.Lock()
+			sw.beats[uri] = t
+			sw.mu.Unlock()
+		case <-tick.C:
+			beats, err := sw.cl.queryHeartbeats(sw.p.BaseURI)
+			if err != nil {
+				continue
+			}
+			sw.mu.Lock()
+			sw.beats = beats
+			sw.mu.Unlock()
+		}
+		if !sw.emit() {
+			return
+		}
+	}
+}
+
+// emit diffs the current heartbeats against the last known state and
+// writes the resulting events, returning false if the watcher was stopped
+func (sw *ServiceWatcher) emit() bool {
+	svcs := sw.Services()
+	evs := []*DiscoveryEvent{}
+	seen := make(map[string]bool)
+	check := func(uri string, alive bool, ev *DiscoveryEvent) {
+		seen[uri] = true
+		prev, ok := sw.known[uri]
+		sw.known[uri] = ev
+		switch {
+		case !ok:
+			ev.Type = DiscoveryAdded
+		case wasAlive(prev) != alive:
+			ev.Type = DiscoveryChanged
+		default:
+			return
+		}
+		evs = append(evs, ev)
+	}
+	for _, s := range svcs {
+		check(s.URI, s.Alive, &DiscoveryEvent{Service: s})
+		for _, i := range s.Interfaces {
+			check(i.URI, i.Alive, &DiscoveryEvent{Service: s, Interface: i})
+		}
+	}
+	for uri, prev := range sw.known {
+		if !seen[uri] {
+			delete(sw.known, uri)
+			evs = append(evs, &DiscoveryEvent{
+				Type:      DiscoveryRemoved,
+				Service:   prev.Service,
+				Interface: prev.Interface,
+			})
+		}
+	}
+	for _, ev := range evs {
+		select {
+		case sw.events <- ev:
+		case <-sw.stop:
+			return false
+		}
+	}
+	return true
+}
+
+func wasAlive(ev *DiscoveryEvent) bool {
+	if ev.Interface != nil {
+		return ev.Interface.Alive
+	}
+	return ev.Service.Alive
+}
@@ -0,0 +1,180 @@
+package bw2bind
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBuildServiceTree(t *testing.T) {
+	now := time.Unix(1000000, 0)
+	fresh, stale := now.Add(-time.Second), now.Add(-time.Hour)
+	type iface struct {
+		uri, prefix, name string
+		alive             bool
+	}
+	type svc struct {
+		uri, name string
+		alive     bool
+		ifaces    []iface
+	}
+	tests := []struct {
+		name  string
+		beats map[string]time.Time
+		want  []svc
+	}{
+		{"empty", map[string]time.Time{}, nil},
+		{
+			"service with interfaces",
+			map[string]time.Time{
+				"ns/base/s.a":             fresh,
+				"ns/base/s.a/dev/i.x":     fresh,
+				"ns/base/s.a/dev/sub/i.y": stale,
+				"ns/base/s.a/i.z":         fresh,
+			},
+			[]svc{{"ns/base/s.a", "s.a", true, []iface{
+				{"ns/base/s.a/i.z", "", "i.z", true},
+				{"ns/base/s.a/dev/i.x", "dev", "i.x", true},
+				{"ns/base/s.a/dev/sub/i.y", "dev/sub", "i.y", false},
+			}}},
+		},
+		{
+			"several services at different depths",
+			map[string]time.Time{
+				"ns/base/s.b":            stale,
+				"ns/base/site/s.a":       fresh,
+				"ns/base/site/s.a/p/i.x": fresh,
+				"ns/base/s.b/p/i.y":      fresh,
+			},
+			[]svc{
+				{"ns/base/s.b", "s.b", false, []iface{{"ns/base/s.b/p/i.y", "p", "i.y", true}}},
+				{"ns/base/site/s.a", "s.a", true, []iface{{"ns/base/site/s.a/p/i.x", "p", "i.x", true}}},
+			},
+		},
+		{
+			"every heartbeat below a service is one of its interfaces",
+			map[string]time.Time{
+				"ns/s.outer":           fresh,
+				"ns/s.outer/s.inner":   fresh,
+				"ns/s.outer/x/i.thing": fresh,
+			},
+			[]svc{{"ns/s.outer", "s.outer", true, []iface{
+				{"ns/s.outer/s.inner", "", "s.inner", true},
+				{"ns/s.outer/x/i.thing", "x", "i.thing", true},
+			}}},
+		},
+	}
+	for _, tt := range tests {
+		var got []svc
+		for _, s := range buildServiceTree(tt.beats, time.Minute, now) {
+			gs := svc{s.URI, s.Name, s.Alive, nil}
+			for _, i := range s.Interfaces {
+				if i.Service != s.URI {
+					t.Errorf("%s: interface %s has service %s", tt.name, i.URI, i.Service)
+				}
+				gs.ifaces = append(gs.ifaces, iface{i.URI, i.Prefix, i.Name, i.Alive})
+			}
+			got = append(got, gs)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s:\n got %+v\nwant %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestBuildServiceTreeStaleness(t *testing.T) {
+	now := time.Unix(1000000, 0)
+	tests := []struct {
+		age   time.Duration
+		alive bool
+	}{
+		{0, true},
+		{30 * time.Second, true},
+		{time.Minute, true},
+		{time.Minute + time.Nanosecond, false},
+		{time.Hour, false},
+		// heartbeats from a clock that is ahead are alive
+		{-time.Hour, true},
+	}
+	for _, tt := range tests {
+		svcs := buildServiceTree(map[string]time.Time{"ns/s.a": now.Add(-tt.age)}, time.Minute, now)
+		if svcs[0].Alive != tt.alive {
+			t.Errorf("heartbeat %s old: alive is %v", tt.age, svcs[0].Alive)
+		}
+	}
+}
+
+func TestParseHeartbeat(t *testing.T) {
+	ts := time.Unix(1500000000, 42)
+	po := CreateMetadataPayloadObject(&MetadataTuple{Value: "1", Timestamp: ts.UnixNano()})
+	uri, got, ok := parseHeartbeat(&SimpleMessage{URI: "ns/s.a/!meta/lastalive", POs: []PayloadObject{po}})
+	if !ok || uri != "ns/s.a" || !got.Equal(ts) {
+		t.Errorf("parsed heartbeat as %q %v %v", uri, got, ok)
+	}
+	if _, _, ok := parseHeartbeat(&SimpleMessage{URI: "ns/s.a/!meta/other", POs: []PayloadObject{po}}); ok {
+		t.Error("parsed a heartbeat from another metadata key")
+	}
+	if _, _, ok := parseHeartbeat(&SimpleMessage{URI: "ns/s.a/!meta/lastalive"}); ok {
+		t.Error("parsed a heartbeat without a metadata PO")
+	}
+}
+
+func TestServiceWatcherEvents(t *testing.T) {
+	sw := &ServiceWatcher{
+		p:      DiscoveryParams{StaleAfter: time.Minute},
+		events: make(chan *DiscoveryEvent, 10),
+		stop:   make(chan struct{}),
+		known:  make(map[string]*DiscoveryEvent),
+		beats: map[string]time.Time{
+			"ns/s.a":     time.Now(),
+			"ns/s.a/i.x": time.Now(),
+			"ns/s.b":     time.Now(),
+		},
+	}
+	type ev struct {
+		typ DiscoveryEventType
+		uri string
+	}
+	next := func(step string, want []ev) {
+		if !sw.emit() {
+			t.Fatalf("%s: emit reported the watcher stopped", step)
+		}
+		got := []ev{}
+		for len(sw.events) > 0 {
+			e := <-sw.events
+			uri := e.Service.URI
+			if e.Interface != nil {
+				uri = e.Interface.URI
+			}
+			got = append(got, ev{e.Type, uri})
+		}
+		if len(got) != len(want) {
+			t.Fatalf("%s: got events %v, want %v", step, got, want)
+		}
+		for _, w := range want {
+			found := false
+			for _, g := range got {
+				found = found || g == w
+			}
+			if !found {
+				t.Errorf("%s: missing event %v in %v", step, w, got)
+			}
+		}
+	}
+	next("initial", []ev{{DiscoveryAdded, "ns/s.a"}, {DiscoveryAdded, "ns/s.a/i.x"}, {DiscoveryAdded, "ns/s.b"}})
+	next("unchanged", nil)
+	sw.beats["ns/s.a/i.x"] = time.Now().Add(-time.Hour)
+	next("interface heartbeat lapsed", []ev{{DiscoveryChanged, "ns/s.a/i.x"}})
+	sw.beats["ns/s.a/i.x"] = time.Now()
+	next("interface heartbeat resumed", []ev{{DiscoveryChanged, "ns/s.a/i.x"}})
+	delete(sw.beats, "ns/s.b")
+	next("service removed", []ev{{DiscoveryRemoved, "ns/s.b"}})
+	close(sw.stop)
+	sw.beats["ns/s.c"] = time.Now()
+	for i := 0; i < cap(sw.events); i++ {
+		sw.events <- &DiscoveryEvent{}
+	}
+	if sw.emit() {
+		t.Error("emit with a full channel after Stop did not report the watcher stopped")
+	}
+}
@@ -0,0 +1,136 @@
+package bw2bind
+
+import (
+	"fmt"
+	"hash/fnv"
+	"runtime"
+	"sync"
+)
+
+type DispatchMode int
+
+const (
+	// Call the handler for one message at a time, in order of arrival
+	DispatchSerial DispatchMode = iota
+	// Call the handler concurrently from a bounded number of workers, with
+	// no ordering guarantee
+	DispatchPool
+	// Call the handler concurrently from a bounded number of workers, but
+	// messages with the same key are handled one at a time, in order
+	DispatchKeyed
+)
+
+// DispatchOptions controls how messages on a subscription are handed to
+// its callback. A nil *DispatchOptions means DispatchSerial.
+type DispatchOptions struct {
+	Mode DispatchMode
+	// The number of concurrent handlers for DispatchPool and DispatchKeyed,
+	// defaults to runtime.NumCPU()
+	Workers int
+	// The ordering key for DispatchKeyed, defaults to KeyByURI
+	Key func(*SimpleMessage) string
+}
+
+// KeyByURI orders DispatchKeyed messages per URI, which is useful for
+// wildcard subscriptions
+func KeyByURI(sm *SimpleMessage) string {
+	return sm.URI
+}
+
+// KeyByPOField returns a key function for DispatchKeyed that decodes the
+// first PO of the given dot form as a MsgPack, JSON or YAML map and uses
+// the given field. Messages without the PO or field share the empty key.
+func KeyByPOField(df string, field string) func(*SimpleMessage) string {
+	return func(sm *SimpleMessage) string {
+		po := sm.GetOnePODF(df)
+		if po == nil {
+			return ""
+		}
+		m := make(map[string]interface{})
+		if err := PayloadObjectValueInto(po, &m); err != nil {
+			return ""
+		}
+		v, ok := m[field]
+		if !ok {
+			return ""
+		}
+		return fmt.Sprint(v)
+	}
+}
+
+// dispatcher calls a handler according to DispatchOptions, recovering
+// panics and passing them to report
+type dispatcher struct {
+	uri    string
+	cb     func(*SimpleMessage)
+	report func(error)
+	key    func(*SimpleMessage) string
+	queues []chan *SimpleMessage
+	wg     sync.WaitGroup
+}
+
+func newDispatcher(uri string, opts *DispatchOptions, cb func(*SimpleMessage), report func(error)) *dispatcher {
+	d := &dispatcher{uri: uri, cb: cb, report: report}
+	if opts == nil || opts.Mode == DispatchSerial {
+		return d
+	}
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	nq := 1
+	if opts.Mode == DispatchKeyed {
+		nq = workers
+		d.key = opts.Key
+		if d.key == nil {
+			d.key = KeyByURI
+		}
+	}
+	d.queues = make([]chan *SimpleMessage, nq)
+	for i := range d.queues {
+		d.queues[i] = make(chan *SimpleMessage, 10)
+	}
+	for i := 0; i < workers; i++ {
+		q := d.queues[i%nq]
+		d.wg.Add(1)
+		go func() {
+			defer d.wg.Done()
+			for sm := range q {
+				d.call(sm)
+			}
+		}()
+	}
+	return d
+}
+
+func (d *dispatcher) call(sm *SimpleMessage) {
+	defer func() {
+		if r := recover(); r != nil {
+			d.report(fmt.Errorf("panic in handler for %s: %v", d.uri, r))
+		}
+	}()
+	d.cb(sm)
+}
+
+// dispatch hands the message to the handler, blocking if all the workers
+// are busy and their queues are full
+func (d *dispatcher) dispatch(sm *SimpleMessage) {
+	switch {
+	case d.queues == nil:
+		d.call(sm)
+	case d.key == nil:
+		d.queues[0] <- sm
+	default:
+		h := fnv.New32a()
+		h.Write([]byte(d.key(sm)))
+		d.queues[h.Sum32()%uint32(len(d.queues))] <- sm
+	}
+}
+
+// close waits for all queued and in-flight handlers to finish
+func (d *dispatcher) close() {
+	for _, q := range d.queues {
+		close(q)
+	}
+	d.wg.Wait()
+}
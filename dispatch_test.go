@@ -0,0 +1,117 @@
+package bw2bind
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDispatchSerialOrder(t *testing.T) {
+	var got []string
+	d := newDispatcher("a/b", nil, func(sm *SimpleMessage) {
+		got = append(got, sm.From)
+	}, func(err error) { t.Error(err) })
+	for i := 0; i < 20; i++ {
+		d.dispatch(&SimpleMessage{From: strconv.Itoa(i)})
+	}
+	d.close()
+	for i, f := range got {
+		if f != strconv.Itoa(i) {
+			t.Fatalf("message %d handled as %s", i, f)
+		}
+	}
+	if len(got) != 20 {
+		t.Fatalf("handled %d of 20 messages", len(got))
+	}
+}
+
+func TestDispatchKeyedOrder(t *testing.T) {
+	var mu sync.Mutex
+	seen := make(map[string][]int)
+	opts := &DispatchOptions{Mode: DispatchKeyed, Workers: 4}
+	d := newDispatcher("a/+", opts, func(sm *SimpleMessage) {
+		n, _ := strconv.Atoi(sm.From)
+		if n%3 == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		mu.Lock()
+		seen[sm.URI] = append(seen[sm.URI], n)
+		mu.Unlock()
+	}, func(err error) { t.Error(err) })
+	for i := 0; i < 200; i++ {
+		d.dispatch(&SimpleMessage{URI: "a/" + strconv.Itoa(i%7), From: strconv.Itoa(i)})
+	}
+	d.close()
+	total := 0
+	for uri, ns := range seen {
+		total += len(ns)
+		for i := 1; i < len(ns); i++ {
+			if ns[i] < ns[i-1] {
+				t.Fatalf("messages on %s handled out of order: %v", uri, ns)
+			}
+		}
+	}
+	if total != 200 {
+		t.Fatalf("handled %d of 200 messages", total)
+	}
+}
+
+func TestDispatchPoolBound(t *testing.T) {
+	var mu sync.Mutex
+	active, max, total := 0, 0, 0
+	opts := &DispatchOptions{Mode: DispatchPool, Workers: 3}
+	d := newDispatcher("a/b", opts, func(sm *SimpleMessage) {
+		mu.Lock()
+		active++
+		if active > max {
+			max = active
+		}
+		mu.Unlock()
+		time.Sleep(2 * time.Millisecond)
+		mu.Lock()
+		active--
+		total++
+		mu.Unlock()
+	}, func(err error) { t.Error(err) })
+	for i := 0; i < 30; i++ {
+		d.dispatch(&SimpleMessage{})
+	}
+	d.close()
+	if max > 3 {
+		t.Errorf("%d handlers ran at once with 3 workers", max)
+	}
+	if total != 30 {
+		t.Errorf("handled %d of 30 messages", total)
+	}
+}
+
+func TestDispatchRecoversPanics(t *testing.T) {
+	for _, opts := range []*DispatchOptions{nil, {Mode: DispatchPool, Workers: 2}, {Mode: DispatchKeyed, Workers: 2}} {
+		var mu sync.Mutex
+		var errs []error
+		handled := 0
+		d := newDispatcher("a/b", opts, func(sm *SimpleMessage) {
+			if sm.From == "bad" {
+				panic("boom")
+			}
+			mu.Lock()
+			handled++
+			mu.Unlock()
+		}, func(err error) {
+			mu.Lock()
+			errs = append(errs, err)
+			mu.Unlock()
+		})
+		d.dispatch(&SimpleMessage{From: "bad"})
+		d.dispatch(&SimpleMessage{From: "good"})
+		d.close()
+		if len(errs) != 1 || !strings.Contains(errs[0].Error(), "panic in handler for a/b: boom") {
+			t.Errorf("mode %v: panic reported as %v", opts, errs)
+		}
+		if handled != 1 {
+			t.Errorf("mode %v: handled %d messages after a panic, expected 1", opts, handled)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package bw2bind
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/immesys/bw2/crypto"
+	"github.com/immesys/bw2/objects"
+)
+
+// DRStatus is the designated router state of a namespace
+type DRStatus struct {
+	Namespace string
+	// The VK of the DR the namespace has accepted, if any
+	Active string
+	// The SRV record of the active DR
+	ActiveSRV string
+	// The VKs of the DRs that have offered to route for the namespace
+	Offers []string
+	// Steps the manager has submitted that are not yet confirmed
+	Pending []*DRStep
+}
+
+// Offered returns true if the DR has offered to route for the namespace
+func (s *DRStatus) Offered(drvk string) bool {
+	for _, o := range s.Offers {
+		if o == drvk {
+			return true
+		}
+	}
+	return s.Active == drvk
+}
+
+// DRStep is one blockchain operation performed by a DRManager
+type DRStep struct {
+	// "offer", "accept" or "srv"
+	Action    string
+	Namespace string
+	DR        string
+	Tx        *TxHandle
+	// Confirmations the transaction had when the status was taken
+	Confirmations uint64
+}
+
+func (s *DRStep) String() string {
+	return fmt.Sprintf("%s %s for %s (%d confirmations)", s.Action, s.DR, s.Namespace, s.Confirmations)
+}
+
+// DREnsureParams describes the designated router a namespace should use
+type DREnsureParams struct {
+	// The namespace VK
+	Namespace string
+	// The designated router VK
+	DR string
+	// The SRV record the DR should have, left as is if empty
+	SRV string
+	// The namespace entity, used to accept the offer. If nil the current
+	// entity must be the namespace
+	NSEntity *objects.Entity
+	// The DR entity, used to make the offer and set the SRV record. If nil
+	// the current entity must be the DR
+	DREntity *objects.Entity
+}
+
+// DRManager performs the offer, accept and SRV record steps needed to
+// make a namespace use a designated router, skipping those that are
+// already done
+type DRManager struct {
+	cl *BW2Client
+	// The account that pays for the transactions
+	Account int
+	// Confirmations to wait for after each step, 1 if zero
+	Confirmations uint64
+	mu            sync.Mutex
+	pending       []*DRStep
+}
+
+// NewDRManager returns a designated router manager paying from account
+func (cl *BW2Client) NewDRManager(account int) *DRManager {
+	return &DRManager{cl: cl, Account: account}
+}
+
+// Status returns the designated router state of the namespace and the
+// manager's unconfirmed steps for it
+func (m *DRManager) Status(nsvk string) (*DRStatus, error) {
+	act, srv, offers, err := m.cl.GetDesignatedRouterOffers(nsvk)
+	if err != nil {
+		return nil, err
+	}
+	rv := &DRStatus{Namespace: nsvk, Active: act, ActiveSRV: srv, Offers: offers}
+	m.mu.Lock()
+	steps := append([]*DRStep{}, m.pending...)
+	m.mu.Unlock()
+	for _, s := range steps {
+		if s.Namespace != nsvk {
+			continue
+		}
+		n, err := s.Tx.Confirmations()
+		if err != nil {
+			return nil, err
+		}
+		rv.Pending = append(rv.Pending, &DRStep{Action: s.Action, Namespace: s.Namespace, DR: s.DR, Tx: s.Tx, Confirmations: n})
+	}
+	return rv, nil
+}
+
+// Ensure makes the namespace use the DR with the given SRV record,
+// performing only the steps that are needed and waiting for each to be
+// confirmed before the next. It returns the final status.
+func (m *DRManager) Ensure(ctx context.Context, p *DREnsureParams) (*DRStatus, error) {
+	if p.Namespace == "" || p.DR == "" {
+		return nil, errors.New("namespace and DR are required")
+	}
+	if p.NSEntity != nil && crypto.FmtKey(p.NSEntity.GetVK()) != p.Namespace {
+		return nil, fmt.Errorf("namespace entity is %s, not %s", crypto.FmtKey(p.NSEntity.GetVK()), p.Namespace)
+	}
+	if p.DREntity != nil && crypto.FmtKey(p.DREntity.GetVK()) != p.DR {
+		return nil, fmt.Errorf("DR entity is %s, not %s", crypto.FmtKey(p.DREntity.GetVK()), p.DR)
+	}
+	st, err := m.Status(p.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	if !st.Offered(p.DR) {
+		tx, err := m.cl.drOfferTx(m.Account, p.Namespace, p.DR, p.DREntity)
+		if err != nil {
+			return nil, err
+		}
+		if err := m.await(ctx, "offer", p, tx); err != nil {
+			return nil, err
+		}
+	}
+	if st.Active != p.DR {
+		tx, err := m.cl.drAcceptTx(m.Account, p.Namespace, p.DR, p.NSEntity)
+		if err != nil {
+			return nil, err
+		}
+		if err := m.await(ctx, "accept", p, tx); err != nil {
+			return nil, err
+		}
+	}
+	if p.SRV != "" && (st.Active != p.DR || st.ActiveSRV != p.SRV) {
+		tx, err := m.cl.drSRVTx(m.Account, p.Namespace, p.DR, p.SRV, p.DREntity)
+		if err != nil {
+			return nil, err
+		}
+		if err := m.await(ctx, "srv", p, tx); err != nil {
+			return nil, err
+		}
+	}
+	return m.Status(p.Namespace)
+}
+
+// await records the step as pending until it has the configured number of
+// confirmations
+func (m *DRManager) await(ctx context.Context, action string, p *DREnsureParams, tx *TxHandle) error {
+	step := &DRStep{Action: action, Namespace: p.Namespace, DR: p.DR, Tx: tx}
+	m.mu.Lock()
+	m.pending = append(m.pending, step)
+	m.mu.Unlock()
+	defer func() {
+		m.mu.Lock()
+		for i, s := range m.pending {
+			if s == step {
+				m.pending = append(m.pending[:i], m.pending[i+1:]...)
+				break
+			}
+		}
+		m.mu.Unlock()
+	}()
+	n := m.Confirmations
+	if n == 0 {
+		n = 1
+	}
+	return tx.Wait(ctx, n)
+}
@@ -0,0 +1,157 @@
+package bw2bind
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/immesys/bw2/objects"
+)
+
+// fakeDR is the designated router state of one namespace on a fake router.
+// Offers, acceptances and SRV records take effect as soon as they are
+// submitted, unless fail names the command to reject.
+type fakeDR struct {
+	mu     sync.Mutex
+	dr     string
+	active string
+	srv    string
+	offers []string
+	fail   string
+	ops    []string
+}
+
+func newFakeDR(t *testing.T, dr string) (*fakeDR, *BW2Client) {
+	fd := &fakeDR{dr: dr}
+	_, cl := newFakeRouter(t, func(req *frame, send func(*frame)) {
+		fd.mu.Lock()
+		defer fd.mu.Unlock()
+		if req.Cmd == fd.fail {
+			send(failed(req, "insufficient funds"))
+			return
+		}
+		switch req.Cmd {
+		case cmdBCInteractionParams:
+			send(okay(req, "currentblock", "100"))
+			return
+		case cmdListDROffers:
+			rsp := okay(req, "active", fd.active, "srv", fd.srv)
+			for _, o := range fd.offers {
+				vk, _ := FromBase64(o)
+				rsp.AddPayloadObject(CreateBasePayloadObject(objects.RODesignatedRouterVK, vk))
+			}
+			send(rsp)
+			return
+		case cmdNewDROffer:
+			fd.ops = append(fd.ops, "offer")
+			fd.offers = append(fd.offers, fd.dr)
+		case cmdAcceptDROffer:
+			drvk, _ := req.GetFirstHeader("drvk")
+			fd.ops = append(fd.ops, "accept")
+			fd.active = drvk
+		case cmdUpdateSRVRecord:
+			fd.ops = append(fd.ops, "srv")
+			fd.srv, _ = req.GetFirstHeader("srv")
+		}
+		send(okay(req))
+	})
+	return fd, cl
+}
+
+func TestDRManagerEnsure(t *testing.T) {
+	ns, dr, other := testVK(1), testVK(2), testVK(3)
+	tests := []struct {
+		name   string
+		active string
+		srv    string
+		offers []string
+		want   string
+		ops    string
+	}{
+		{"nothing done", "", "", nil, "dr.example.com:4514", "offer accept srv"},
+		{"already offered", "", "", []string{other, dr}, "dr.example.com:4514", "accept srv"},
+		{"active with another SRV record", dr, "old.example.com:4514", []string{dr}, "dr.example.com:4514", "srv"},
+		{"active with the SRV record", dr, "dr.example.com:4514", []string{dr}, "dr.example.com:4514", ""},
+		{"active, SRV record left as is", dr, "old.example.com:4514", []string{dr}, "", ""},
+		{"another DR active", other, "other.example.com:4514", []string{other}, "dr.example.com:4514", "offer accept srv"},
+	}
+	for _, tt := range tests {
+		fd, cl := newFakeDR(t, dr)
+		fd.active, fd.srv, fd.offers = tt.active, tt.srv, tt.offers
+		m := cl.NewDRManager(0)
+		var st *DRStatus
+		var err error
+		within(t, 5*time.Second, tt.name, func() {
+			st, err = m.Ensure(context.Background(), &DREnsureParams{Namespace: ns, DR: dr, SRV: tt.want})
+		})
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if ops := strings.Join(fd.ops, " "); ops != tt.ops {
+			t.Errorf("%s: performed %q, expected %q", tt.name, ops, tt.ops)
+		}
+		if st.Active != dr || !st.Offered(dr) || len(st.Pending) != 0 {
+			t.Errorf("%s: final status active %s, offers %v, pending %v", tt.name, st.Active, st.Offers, st.Pending)
+		}
+		if tt.want != "" && st.ActiveSRV != tt.want {
+			t.Errorf("%s: SRV record is %s", tt.name, st.ActiveSRV)
+		}
+	}
+}
+
+func TestDRManagerEnsureFailure(t *testing.T) {
+	ns, dr := testVK(1), testVK(2)
+	for _, fail := range []string{cmdNewDROffer, cmdAcceptDROffer, cmdUpdateSRVRecord} {
+		fd, cl := newFakeDR(t, dr)
+		fd.fail = fail
+		m := cl.NewDRManager(0)
+		within(t, 5*time.Second, "Ensure", func() {
+			_, err := m.Ensure(context.Background(), &DREnsureParams{Namespace: ns, DR: dr, SRV: "dr.example.com:4514"})
+			if err == nil || !strings.Contains(err.Error(), "insufficient funds") {
+				t.Errorf("failing %s: Ensure returned %v", fail, err)
+			}
+		})
+		if len(m.pending) != 0 {
+			t.Errorf("failing %s: %d steps left pending", fail, len(m.pending))
+		}
+	}
+	_, cl := newFakeDR(t, dr)
+	if _, err := cl.NewDRManager(0).Ensure(context.Background(), &DREnsureParams{Namespace: ns}); err == nil {
+		t.Error("Ensure without a DR succeeded")
+	}
+}
+
+func TestDRManagerStatus(t *testing.T) {
+	ns, dr, other := testVK(1), testVK(2), testVK(3)
+	fd, cl := newFakeDR(t, dr)
+	fd.active, fd.srv, fd.offers = other, "other.example.com:4514", []string{other, dr}
+	m := cl.NewDRManager(0)
+	mined, waiting := &flag{set: true}, &flag{}
+	m.pending = []*DRStep{
+		{Action: "accept", Namespace: ns, DR: dr, Tx: cl.newTx("accept", mined.mined)},
+		{Action: "offer", Namespace: testVK(4), DR: dr, Tx: cl.newTx("other namespace", waiting.mined)},
+		{Action: "srv", Namespace: ns, DR: dr, Tx: cl.newTx("srv", waiting.mined)},
+	}
+	st, err := m.Status(ns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Active != other || st.ActiveSRV != "other.example.com:4514" || !st.Offered(dr) || st.Offered(testVK(4)) {
+		t.Errorf("status is active %s SRV %s offers %v", st.Active, st.ActiveSRV, st.Offers)
+	}
+	var pending []string
+	for _, s := range st.Pending {
+		pending = append(pending, s.String())
+	}
+	want := []string{
+		fmt.Sprintf("accept %s for %s (1 confirmations)", dr, ns),
+		fmt.Sprintf("srv %s for %s (0 confirmations)", dr, ns),
+	}
+	if fmt.Sprint(pending) != fmt.Sprint(want) {
+		t.Errorf("pending steps are %v, expected %v", pending, want)
+	}
+}
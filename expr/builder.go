@@ -0,0 +1,443 @@
+package expr
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/immesys/bw2bind/adps"
+)
+
+// Expr is a view expression built with the functions in this package. Use
+// M to obtain the structure that BW2Client.CreateView expects, e.g.
+//
+//	e := expr.And(
+//		expr.URI("ns/*"),
+//		expr.Meta("type", expr.Eq("light")),
+//		expr.HasInterface("i.xbos.light"))
+//	if err := e.Validate(); err != nil { ... }
+//	view, err := cl.CreateView(e.M())
+type Expr interface {
+	// M returns the msgpack structure for this expression
+	M() M
+	// Validate checks the expression for errors that the router would
+	// otherwise reject
+	Validate() error
+	// String renders the expression on a single line for debugging
+	String() string
+	pretty(b *strings.Builder, indent string)
+	eval(d *Descriptor) *Explanation
+}
+
+// Cond is a condition on the value of a metadata key, see Meta
+type Cond interface {
+	value() interface{}
+	validate() error
+	String() string
+}
+
+// Pretty renders the expression as an indented tree
+func Pretty(e Expr) string {
+	b := &strings.Builder{}
+	e.pretty(b, "")
+	return b.String()
+}
+
+type boolExpr struct {
+	op   string
+	kids []Expr
+}
+
+// And matches interfaces that match all of the given expressions
+func And(e ...Expr) Expr {
+	return &boolExpr{op: "$and", kids: e}
+}
+
+// Or matches interfaces that match any of the given expressions
+func Or(e ...Expr) Expr {
+	return &boolExpr{op: "$or", kids: e}
+}
+
+func (e *boolExpr) M() M {
+	kids := make([]interface{}, len(e.kids))
+	for i, k := range e.kids {
+		kids[i] = k.M()
+	}
+	return M{e.op: kids}
+}
+
+func (e *boolExpr) Validate() error {
+	if len(e.kids) == 0 {
+		return fmt.Errorf("%s requires at least one operand", e.name())
+	}
+	for _, k := range e.kids {
+		if k == nil {
+			return fmt.Errorf("%s has a nil operand", e.name())
+		}
+		if err := k.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *boolExpr) name() string {
+	return strings.TrimPrefix(e.op, "$")
+}
+
+func (e *boolExpr) String() string {
+	parts := make([]string, len(e.kids))
+	for i, k := range e.kids {
+		parts[i] = k.String()
+	}
+	return e.name() + "(" + strings.Join(parts, ", ") + ")"
+}
+
+func (e *boolExpr) pretty(b *strings.Builder, indent string) {
+	b.WriteString(indent + e.name() + "\n")
+	for _, k := range e.kids {
+		k.pretty(b, indent+"  ")
+	}
+}
+
+type notExpr struct {
+	kid Expr
+}
+
+// Not matches interfaces that do not match the given expression
+func Not(e Expr) Expr {
+	return &notExpr{kid: e}
+}
+
+func (e *notExpr) M() M {
+	return M{"$not": e.kid.M()}
+}
+
+func (e *notExpr) Validate() error {
+	if e.kid == nil {
+		return errors.New("not has a nil operand")
+	}
+	return e.kid.Validate()
+}
+
+func (e *notExpr) String() string {
+	return "not(" + e.kid.String() + ")"
+}
+
+func (e *notExpr) pretty(b *strings.Builder, indent string) {
+	b.WriteString(indent + "not\n")
+	e.kid.pretty(b, indent+"  ")
+}
+
+type leafExpr struct {
+	key string
+	val string
+}
+
+// URI matches interfaces whose URI matches the given pattern, which may
+// contain + and * wildcards
+func URI(pattern string) Expr {
+	return &leafExpr{key: "uri", val: pattern}
+}
+
+// Service matches interfaces on services with the given name, e.g. "s.hue"
+func Service(name string) Expr {
+	return &leafExpr{key: "svc", val: name}
+}
+
+// HasInterface matches interfaces with the given name, e.g. "i.xbos.light"
+func HasInterface(name string) Expr {
+	return &leafExpr{key: "iface", val: name}
+}
+
+func (e *leafExpr) M() M {
+	return M{e.key: e.val}
+}
+
+func (e *leafExpr) Validate() error {
+	if e.val == "" {
+		return fmt.Errorf("%s must not be empty", e.key)
+	}
+	if e.key == "uri" {
+		return ValidateURIPattern(e.val)
+	}
+	if strings.Contains(e.val, "/") {
+		return fmt.Errorf("%s %q must not contain /", e.key, e.val)
+	}
+	return nil
+}
+
+func (e *leafExpr) String() string {
+	return fmt.Sprintf("%s(%q)", e.key, e.val)
+}
+
+func (e *leafExpr) pretty(b *strings.Builder, indent string) {
+	b.WriteString(indent + e.String() + "\n")
+}
+
+type nsExpr struct {
+	ns []string
+}
+
+// Namespace matches interfaces in any of the given namespaces
+func Namespace(ns ...string) Expr {
+	return &nsExpr{ns: ns}
+}
+
+func (e *nsExpr) M() M {
+	return M{"ns": A(e.ns)}
+}
+
+func (e *nsExpr) Validate() error {
+	if len(e.ns) == 0 {
+		return errors.New("ns requires at least one namespace")
+	}
+	for _, ns := range e.ns {
+		if ns == "" || strings.ContainsAny(ns, "/+*") {
+			return fmt.Errorf("invalid namespace %q", ns)
+		}
+	}
+	return nil
+}
+
+func (e *nsExpr) String() string {
+	parts := make([]string, len(e.ns))
+	for i, ns := range e.ns {
+		parts[i] = fmt.Sprintf("%q", ns)
+	}
+	return "ns(" + strings.Join(parts, ", ") + ")"
+}
+
+func (e *nsExpr) pretty(b *strings.Builder, indent string) {
+	b.WriteString(indent + e.String() + "\n")
+}
+
+type metaExpr struct {
+	key  string
+	cond Cond
+}
+
+// Meta matches interfaces whose metadata key satisfies the condition
+func Meta(key string, c Cond) Expr {
+	return &metaExpr{key: key, cond: c}
+}
+
+// HasMeta matches interfaces that have the metadata key set to any value
+func HasMeta(key string) Expr {
+	return &metaExpr{key: key, cond: hasCond{}}
+}
+
+func (e *metaExpr) M() M {
+	return M{"meta": M{e.key: e.cond.value()}}
+}
+
+func (e *metaExpr) Validate() error {
+	if e.key == "" || strings.ContainsAny(e.key, "/+*") {
+		return fmt.Errorf("invalid metadata key %q", e.key)
+	}
+	if e.cond == nil {
+		return fmt.Errorf("meta %q has a nil condition", e.key)
+	}
+	return e.cond.validate()
+}
+
+func (e *metaExpr) String() string {
+	return fmt.Sprintf("meta(%q %s)", e.key, e.cond)
+}
+
+func (e *metaExpr) pretty(b *strings.Builder, indent string) {
+	b.WriteString(indent + e.String() + "\n")
+}
+
+type eqCond string
+
+// Eq is satisfied if the metadata value is exactly v
+func Eq(v string) Cond {
+	return eqCond(v)
+}
+
+func (c eqCond) value() interface{} { return string(c) }
+func (c eqCond) validate() error    { return nil }
+func (c eqCond) String() string     { return fmt.Sprintf("== %q", string(c)) }
+
+type reCond string
+
+// Regex is satisfied if the metadata value matches the regular expression
+func Regex(re string) Cond {
+	return reCond(re)
+}
+
+func (c reCond) value() interface{} { return M{"$re": string(c)} }
+func (c reCond) validate() error {
+	if _, err := regexp.Compile(string(c)); err != nil {
+		return fmt.Errorf("invalid regex %q: %v", string(c), err)
+	}
+	return nil
+}
+func (c reCond) String() string { return fmt.Sprintf("=~ %q", string(c)) }
+
+type hasCond struct{}
+
+func (c hasCond) value() interface{} { return M{"$has": true} }
+func (c hasCond) validate() error    { return nil }
+func (c hasCond) String() string     { return "exists" }
+
+// ValidateURIPattern checks that a URI pattern has a namespace, no empty
+// segments, + only as a whole segment and at most one * segment
+func ValidateURIPattern(pattern string) error {
+	return adps.ValidatePattern(pattern)
+}
+
+// Parse converts a msgpack view expression, as produced by Expr.M, back
+// into an Expr so that hand written expressions can be validated
+func Parse(m M) (Expr, error) {
+	if len(m) == 0 {
+		return nil, errors.New("empty view expression")
+	}
+	if len(m) != 1 {
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		parts := make([]Expr, 0, len(m))
+		for _, k := range keys {
+			e, err := Parse(M{k: m[k]})
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, e)
+		}
+		return And(parts...), nil
+	}
+	for k, v := range m {
+		switch k {
+		case "$and", "$or":
+			vs, ok := toSlice(v)
+			if !ok {
+				return nil, fmt.Errorf("%s expects a list", k)
+			}
+			kids := make([]Expr, len(vs))
+			for i, kv := range vs {
+				km, ok := toM(kv)
+				if !ok {
+					return nil, fmt.Errorf("%s operand %d is not a map", k, i)
+				}
+				e, err := Parse(km)
+				if err != nil {
+					return nil, err
+				}
+				kids[i] = e
+			}
+			return &boolExpr{op: k, kids: kids}, nil
+		case "$not":
+			km, ok := toM(v)
+			if !ok {
+				return nil, errors.New("$not expects a map")
+			}
+			e, err := Parse(km)
+			if err != nil {
+				return nil, err
+			}
+			return Not(e), nil
+		case "uri", "svc", "iface":
+			s, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s expects a string", k)
+			}
+			return &leafExpr{key: k, val: s}, nil
+		case "ns":
+			vs, ok := toSlice(v)
+			if !ok {
+				return nil, errors.New("ns expects a list")
+			}
+			ns := make([]string, len(vs))
+			for i, nv := range vs {
+				if ns[i], ok = nv.(string); !ok {
+					return nil, errors.New("ns expects a list of strings")
+				}
+			}
+			return Namespace(ns...), nil
+		case "meta":
+			mm, ok := toM(v)
+			if !ok {
+				return nil, errors.New("meta expects a map")
+			}
+			keys := make([]string, 0, len(mm))
+			for mk := range mm {
+				keys = append(keys, mk)
+			}
+			sort.Strings(keys)
+			parts := make([]Expr, 0, len(mm))
+			for _, mk := range keys {
+				c, err := parseCond(mk, mm[mk])
+				if err != nil {
+					return nil, err
+				}
+				parts = append(parts, Meta(mk, c))
+			}
+			if len(parts) == 1 {
+				return parts[0], nil
+			}
+			return And(parts...), nil
+		default:
+			return nil, fmt.Errorf("unknown view operator %q", k)
+		}
+	}
+	return nil, errors.New("empty view expression")
+}
+
+func parseCond(key string, v interface{}) (Cond, error) {
+	if s, ok := v.(string); ok {
+		return Eq(s), nil
+	}
+	cm, ok := toM(v)
+	if !ok || len(cm) != 1 {
+		return nil, fmt.Errorf("meta %q expects a string or a single operator map", key)
+	}
+	if re, ok := cm["$re"].(string); ok {
+		return Regex(re), nil
+	}
+	if _, ok := cm["$has"]; ok {
+		return hasCond{}, nil
+	}
+	return nil, fmt.Errorf("meta %q has an unknown operator", key)
+}
+
+func toM(v interface{}) (M, bool) {
+	switch t := v.(type) {
+	case M:
+		return t, true
+	case map[string]interface{}:
+		return M(t), true
+	case map[interface{}]interface{}:
+		rv := make(M, len(t))
+		for k, vv := range t {
+			ks, ok := k.(string)
+			if !ok {
+				return nil, false
+			}
+			rv[ks] = vv
+		}
+		return rv, true
+	}
+	return nil, false
+}
+
+func toSlice(v interface{}) ([]interface{}, bool) {
+	switch t := v.(type) {
+	case []interface{}:
+		return t, true
+	case A:
+		rv := make([]interface{}, len(t))
+		for i, s := range t {
+			rv[i] = s
+		}
+		return rv, true
+	case []string:
+		return toSlice(A(t))
+	}
+	return nil, false
+}
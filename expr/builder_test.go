@@ -0,0 +1,106 @@
+package expr
+
+import "testing"
+
+func TestParseRoundTrip(t *testing.T) {
+	exprs := []Expr{
+		URI("ns/a/*"),
+		Service("s.hue"),
+		HasInterface("i.xbos.light"),
+		Namespace("ns1", "ns2"),
+		Meta("type", Eq("light")),
+		Meta("room", Regex("^kitchen")),
+		HasMeta("owner"),
+		Not(URI("ns/+/b")),
+		And(URI("ns/*"), Or(Meta("type", Eq("light")), HasMeta("dimmable"))),
+		Or(Not(Service("s.hue")), And(Namespace("ns"), Meta("room", Regex("[0-9]+")))),
+	}
+	for _, e := range exprs {
+		p, err := Parse(e.M())
+		if err != nil {
+			t.Errorf("Parse(%s): %v", e, err)
+			continue
+		}
+		if p.String() != e.String() {
+			t.Errorf("%s parsed back as %s", e, p)
+		}
+	}
+}
+
+// TestParseMsgpack parses the generic types that msgpack decodes to
+func TestParseMsgpack(t *testing.T) {
+	m := M{"$or": []interface{}{
+		map[interface{}]interface{}{"meta": map[interface{}]interface{}{
+			"room": map[interface{}]interface{}{"$re": "^k"},
+			"type": "light",
+		}},
+		map[interface{}]interface{}{"$not": map[interface{}]interface{}{
+			"meta": map[interface{}]interface{}{"owner": map[interface{}]interface{}{"$has": true}},
+		}},
+		map[interface{}]interface{}{"ns": []interface{}{"a", "b"}},
+	}}
+	e, err := Parse(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `or(and(meta("room" =~ "^k"), meta("type" == "light")), not(meta("owner" exists)), ns("a", "b"))`
+	if e.String() != expected {
+		t.Errorf("parsed as %s, expected %s", e, expected)
+	}
+	// several keys in one map are an implicit and
+	e, err = Parse(M{"svc": "s.hue", "uri": "ns/*"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.String() != `and(svc("s.hue"), uri("ns/*"))` {
+		t.Errorf("implicit and parsed as %s", e)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	bad := []M{
+		{},
+		{"$and": "x"},
+		{"$or": []interface{}{"x"}},
+		{"$not": "x"},
+		{"uri": 5},
+		{"ns": []interface{}{5}},
+		{"meta": "x"},
+		{"meta": M{"k": M{"$gt": 5}}},
+		{"meta": M{"k": M{"$re": "a", "$has": true}}},
+		{"$xor": []interface{}{}},
+	}
+	for _, m := range bad {
+		if e, err := Parse(m); err == nil {
+			t.Errorf("Parse(%v) = %s, expected an error", m, e)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	cases := []struct {
+		e   Expr
+		err bool
+	}{
+		{And(URI("ns/+/*"), Service("s.hue")), false},
+		{URI("ns/a*"), true},
+		{URI("ns/*/a/*"), true},
+		{URI(""), true},
+		{Service("s/hue"), true},
+		{And(), true},
+		{Or(URI("ns/a"), nil), true},
+		{Not(nil), true},
+		{Namespace(), true},
+		{Namespace("ns/a"), true},
+		{Meta("", Eq("x")), true},
+		{Meta("k", nil), true},
+		{Meta("k", Regex("(")), true},
+		{Not(Meta("k", Regex("^a"))), false},
+	}
+	for _, c := range cases {
+		err := c.e.Validate()
+		if (err != nil) != c.err {
+			t.Errorf("%v.Validate() = %v, expected error %v", c.e, err, c.err)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package expr
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/immesys/bw2bind/adps"
+)
+
+// Descriptor holds the properties of an interface that view expressions
+// match against. It mirrors bw2bind.InterfaceDescriptor.
+type Descriptor struct {
+	URI       string
+	Interface string
+	Service   string
+	Namespace string
+	Metadata  map[string]string
+}
+
+// Explanation records why an expression did or did not match a Descriptor
+type Explanation struct {
+	// The expression this node evaluated, as rendered by Expr.String
+	Expr     string
+	Matched  bool
+	Reason   string
+	Children []*Explanation
+}
+
+// Evaluate matches the expression against the descriptor locally, without
+// contacting the router, and explains the result
+func Evaluate(e Expr, d *Descriptor) (bool, *Explanation) {
+	x := e.eval(d)
+	return x.Matched, x
+}
+
+// Matches is like Evaluate but only returns the result
+func Matches(e Expr, d *Descriptor) bool {
+	return e.eval(d).Matched
+}
+
+// String renders the explanation as an indented tree
+func (x *Explanation) String() string {
+	b := &strings.Builder{}
+	x.write(b, "")
+	return b.String()
+}
+
+func (x *Explanation) write(b *strings.Builder, indent string) {
+	res := "no match"
+	if x.Matched {
+		res = "match"
+	}
+	b.WriteString(fmt.Sprintf("%s%s: %s", indent, x.Expr, res))
+	if x.Reason != "" {
+		b.WriteString(" (" + x.Reason + ")")
+	}
+	b.WriteString("\n")
+	for _, c := range x.Children {
+		c.write(b, indent+"  ")
+	}
+}
+
+func (e *boolExpr) eval(d *Descriptor) *Explanation {
+	x := &Explanation{Expr: e.String(), Matched: e.op == "$and"}
+	for _, k := range e.kids {
+		kx := k.eval(d)
+		x.Children = append(x.Children, kx)
+		if e.op == "$and" && !kx.Matched {
+			x.Matched = false
+		}
+		if e.op == "$or" && kx.Matched {
+			x.Matched = true
+		}
+	}
+	return x
+}
+
+func (e *notExpr) eval(d *Descriptor) *Explanation {
+	kx := e.kid.eval(d)
+	return &Explanation{Expr: e.String(), Matched: !kx.Matched, Children: []*Explanation{kx}}
+}
+
+func (e *leafExpr) eval(d *Descriptor) *Explanation {
+	x := &Explanation{Expr: e.String()}
+	var have string
+	switch e.key {
+	case "uri":
+		x.Matched = MatchURI(e.val, d.URI)
+		x.Reason = fmt.Sprintf("uri is %q", d.URI)
+		return x
+	case "svc":
+		have = d.Service
+	case "iface":
+		have = d.Interface
+	}
+	x.Matched = have == e.val
+	x.Reason = fmt.Sprintf("%s is %q", e.key, have)
+	return x
+}
+
+func (e *nsExpr) eval(d *Descriptor) *Explanation {
+	ns := d.Namespace
+	if ns == "" {
+		ns = strings.SplitN(d.URI, "/", 2)[0]
+	}
+	x := &Explanation{Expr: e.String(), Reason: fmt.Sprintf("namespace is %q", ns)}
+	for _, n := range e.ns {
+		if n == ns {
+			x.Matched = true
+		}
+	}
+	return x
+}
+
+func (e *metaExpr) eval(d *Descriptor) *Explanation {
+	x := &Explanation{Expr: e.String()}
+	v, ok := d.Metadata[e.key]
+	if !ok {
+		x.Reason = fmt.Sprintf("%q is not set", e.key)
+		return x
+	}
+	x.Reason = fmt.Sprintf("%q is %q", e.key, v)
+	switch c := e.cond.(type) {
+	case eqCond:
+		x.Matched = v == string(c)
+	case reCond:
+		re, err := regexp.Compile(string(c))
+		if err != nil {
+			x.Reason = err.Error()
+			return x
+		}
+		x.Matched = re.MatchString(v)
+	case hasCond:
+		x.Matched = true
+	}
+	return x
+}
+
+// MatchURI returns true if the URI matches the pattern, where + matches
+// exactly one segment and * matches zero or more segments
+func MatchURI(pattern, uri string) bool {
+	return adps.Matches(pattern, uri)
+}
@@ -0,0 +1,111 @@
+package expr
+
+import (
+	"strings"
+	"testing"
+)
+
+var light = &Descriptor{
+	URI:       "ns/building/floor1/light1/i.xbos.light",
+	Interface: "i.xbos.light",
+	Service:   "s.hue",
+	Metadata:  map[string]string{"type": "light", "room": "kitchen-2"},
+}
+
+func TestEvaluate(t *testing.T) {
+	cases := []struct {
+		e  Expr
+		ok bool
+	}{
+		{URI("ns/building/*"), true},
+		{URI("ns/+/floor1/*"), true},
+		{URI("ns/*/i.xbos.light"), true},
+		{URI("ns/building/+"), false},
+		{URI("other/*"), false},
+		{Service("s.hue"), true},
+		{Service("s.lifx"), false},
+		{HasInterface("i.xbos.light"), true},
+		{Namespace("other", "ns"), true},
+		{Namespace("other"), false},
+		{Meta("type", Eq("light")), true},
+		{Meta("type", Eq("Light")), false},
+		{Meta("room", Regex("^kitchen-[0-9]+$")), true},
+		{Meta("room", Regex("^bath")), false},
+		{Meta("room", Regex("(")), false},
+		{HasMeta("room"), true},
+		{HasMeta("owner"), false},
+		{And(URI("ns/*"), Service("s.hue"), HasMeta("type")), true},
+		{And(URI("ns/*"), Service("s.lifx")), false},
+		{Or(Service("s.lifx"), Meta("type", Eq("light"))), true},
+		{Or(Service("s.lifx"), HasMeta("owner")), false},
+		{Not(Service("s.lifx")), true},
+		{Not(Or(Service("s.hue"), HasMeta("owner"))), false},
+	}
+	for _, c := range cases {
+		ok, x := Evaluate(c.e, light)
+		if ok != c.ok {
+			t.Errorf("%s = %v, expected %v\n%s", c.e, ok, c.ok, x)
+		}
+		if x.Matched != ok || Matches(c.e, light) != ok {
+			t.Errorf("%s: explanation and Matches disagree with Evaluate", c.e)
+		}
+		if x.Expr != c.e.String() {
+			t.Errorf("%s: explanation is for %s", c.e, x.Expr)
+		}
+	}
+}
+
+func TestEvaluateNamespaceFromURI(t *testing.T) {
+	d := &Descriptor{URI: "ns2/a/b", Namespace: "ns"}
+	if !Matches(Namespace("ns"), d) || Matches(Namespace("ns2"), d) {
+		t.Error("an explicit Namespace should take precedence over the URI")
+	}
+	d.Namespace = ""
+	if !Matches(Namespace("ns2"), d) {
+		t.Error("the namespace should be taken from the URI")
+	}
+}
+
+func TestExplanation(t *testing.T) {
+	e := And(Service("s.hue"), Not(Meta("type", Eq("light"))))
+	ok, x := Evaluate(e, light)
+	if ok {
+		t.Fatal("expected no match")
+	}
+	if len(x.Children) != 2 || !x.Children[0].Matched || x.Children[1].Matched {
+		t.Fatalf("unexpected children: %s", x)
+	}
+	if kid := x.Children[1].Children; len(kid) != 1 || !kid[0].Matched || kid[0].Reason != `"type" is "light"` {
+		t.Fatalf("unexpected explanation of not: %s", x)
+	}
+	expected := strings.Join([]string{
+		`and(svc("s.hue"), not(meta("type" == "light"))): no match`,
+		`  svc("s.hue"): match (svc is "s.hue")`,
+		`  not(meta("type" == "light")): no match`,
+		`    meta("type" == "light"): match ("type" is "light")`,
+		``,
+	}, "\n")
+	if x.String() != expected {
+		t.Errorf("explanation is\n%s\nexpected\n%s", x, expected)
+	}
+}
+
+func TestMatchURI(t *testing.T) {
+	cases := []struct {
+		pattern, uri string
+		ok           bool
+	}{
+		{"ns/a/b", "ns/a/b", true},
+		{"ns/+/b", "ns/a/b", true},
+		{"ns/+/b", "ns/b", false},
+		{"ns/*", "ns", true},
+		{"ns/a/*/b", "ns/a/b", true},
+		{"ns/a/*/b", "ns/a/x/y/b", true},
+		{"ns/a/*/b", "ns/a/x/y/c", false},
+	}
+	for _, c := range cases {
+		if ok := MatchURI(c.pattern, c.uri); ok != c.ok {
+			t.Errorf("MatchURI(%q, %q) = %v, expected %v", c.pattern, c.uri, ok, c.ok)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package bw2bind
+
+import (
+	"bufio"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRouter speaks the frame protocol on a local socket so that client
+// code can be tested without a BOSSWAVE router. Every request frame is
+// passed to the handler, which replies with send.
+type fakeRouter struct {
+	t       *testing.T
+	mu      sync.Mutex
+	out     *bufio.Writer
+	handler func(fr *frame, send func(*frame))
+	frames  []*frame
+}
+
+// newFakeRouter starts a fake router and returns a client connected to it.
+// The connection is never closed, as the client exits the process when
+// its connection fails.
+func newFakeRouter(t *testing.T, handler func(fr *frame, send func(*frame))) (*fakeRouter, *BW2Client) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fr := &fakeRouter{t: t, handler: handler}
+	go func() {
+		conn, err := ln.Accept()
+		ln.Close()
+		if err != nil {
+			return
+		}
+		fr.out = bufio.NewWriter(conn)
+		helo := createFrame(cmdHello, 0)
+		helo.AddHeader("version", "fake")
+		fr.send(helo)
+		in := bufio.NewReader(conn)
+		for {
+			req, err := loadFrameFromStream(in)
+			if err != nil {
+				return
+			}
+			fr.mu.Lock()
+			fr.frames = append(fr.frames, req)
+			fr.mu.Unlock()
+			fr.handler(req, fr.send)
+		}
+	}()
+	cl, err := Connect(ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fr, cl
+}
+
+func (fr *fakeRouter) send(f *frame) {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	f.WriteToStream(fr.out)
+}
+
+// received returns the requests with the given command seen so far
+func (fr *fakeRouter) received(cmd string) []*frame {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	var rv []*frame
+	for _, f := range fr.frames {
+		if f.Cmd == cmd {
+			rv = append(rv, f)
+		}
+	}
+	return rv
+}
+
+// okay returns a successful response to req with the given headers
+func okay(req *frame, kv ...string) *frame {
+	rv := createFrame(cmdResponse, req.SeqNo)
+	rv.AddHeader("status", "okay")
+	for i := 0; i+1 < len(kv); i += 2 {
+		rv.AddHeader(kv[i], kv[i+1])
+	}
+	return rv
+}
+
+// failed returns an error response to req
+func failed(req *frame, reason string) *frame {
+	rv := createFrame(cmdResponse, req.SeqNo)
+	rv.AddHeader("status", "error")
+	rv.AddHeader("code", "500")
+	rv.AddHeader("reason", reason)
+	return rv
+}
+
+// result returns a result frame for req with the given headers
+func result(req *frame, kv ...string) *frame {
+	rv := createFrame(cmdResult, req.SeqNo)
+	for i := 0; i+1 < len(kv); i += 2 {
+		rv.AddHeader(kv[i], kv[i+1])
+	}
+	return rv
+}
+
+// within fails the test if f does not return within the timeout
+func within(t *testing.T, timeout time.Duration, what string, f func()) {
+	done := make(chan struct{})
+	go func() {
+		f()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		t.Fatalf("%s did not return within %s", what, timeout)
+	}
+}
@@ -0,0 +1,291 @@
+package bw2bind
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/immesys/bw2/crypto"
+	"github.com/immesys/bw2/objects"
+)
+
+// EntityInfo is the inspectable content of an entity
+type EntityInfo struct {
+	VK             string     `json:"vk"`
+	Contact        string     `json:"contact"`
+	Comment        string     `json:"comment"`
+	Created        *time.Time `json:"created,omitempty"`
+	Expiry         *time.Time `json:"expiry,omitempty"`
+	Revokers       []string   `json:"revokers,omitempty"`
+	HasSigningKey  bool       `json:"hassigningkey"`
+	SignatureValid bool       `json:"signaturevalid"`
+}
+
+// DOTInfo is the inspectable content of a Declaration of Trust
+type DOTInfo struct {
+	Hash           string     `json:"hash"`
+	From           string     `json:"from"`
+	To             string     `json:"to"`
+	IsAccess       bool       `json:"isaccess"`
+	URI            string     `json:"uri,omitempty"`
+	Permissions    string     `json:"permissions,omitempty"`
+	TTL            int        `json:"ttl"`
+	Contact        string     `json:"contact"`
+	Comment        string     `json:"comment"`
+	Created        *time.Time `json:"created,omitempty"`
+	Expiry         *time.Time `json:"expiry,omitempty"`
+	Revokers       []string   `json:"revokers,omitempty"`
+	SignatureValid bool       `json:"signaturevalid"`
+}
+
+// ChainInfo is the inspectable content of a DOT chain. DOTs is only
+// populated if the chain is elaborated.
+type ChainInfo struct {
+	Hash       string     `json:"hash"`
+	Elaborated bool       `json:"elaborated"`
+	DOTHashes  []string   `json:"dothashes"`
+	DOTs       []*DOTInfo `json:"dots,omitempty"`
+}
+
+// parseWithHeader parses blob with the given constructor, first assuming it
+// has the one byte type header found in files (as SetEntityFile strips)
+// and then assuming it does not. A parse only counts if valid accepts the
+// object, so an object with a bad signature is an error either way.
+func parseWithHeader(blob []byte, ronums []int, parse func(ronum int, content []byte) (objects.RoutingObject, error), valid func(objects.RoutingObject) bool) (objects.RoutingObject, error) {
+	if len(blob) == 0 {
+		return nil, errors.New("empty object")
+	}
+	var lasterr error
+	try := func(ronum int, content []byte) objects.RoutingObject {
+		ro, err := parse(ronum, content)
+		if err != nil {
+			lasterr = err
+			return nil
+		}
+		if !valid(ro) {
+			lasterr = errors.New("object signature is not valid")
+			return nil
+		}
+		return ro
+	}
+	for _, ronum := range ronums {
+		if int(blob[0]) == ronum {
+			if ro := try(ronum, blob[1:]); ro != nil {
+				return ro, nil
+			}
+		}
+	}
+	for _, ronum := range ronums {
+		if ro := try(ronum, blob); ro != nil {
+			return ro, nil
+		}
+	}
+	return nil, lasterr
+}
+
+func fmtTime(t *time.Time) string {
+	if t == nil {
+		return "never"
+	}
+	return t.Format(time.RFC3339)
+}
+
+func fmtKeys(keys [][]byte) []string {
+	rv := make([]string, len(keys))
+	for i, k := range keys {
+		rv[i] = crypto.FmtKey(k)
+	}
+	return rv
+}
+
+// ParseEntity decodes an entity blob, with or without the file type header
+func ParseEntity(blob []byte) (*EntityInfo, error) {
+	ro, err := parseWithHeader(blob, []int{objects.ROEntityWKey, objects.ROEntity}, objects.NewEntity, func(ro objects.RoutingObject) bool {
+		return ro.(*objects.Entity).SigValid()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entityInfo(ro.(*objects.Entity)), nil
+}
+
+func entityInfo(e *objects.Entity) *EntityInfo {
+	return &EntityInfo{
+		VK:             crypto.FmtKey(e.GetVK()),
+		Contact:        e.GetContact(),
+		Comment:        e.GetComment(),
+		Created:        e.GetCreated(),
+		Expiry:         e.GetExpiry(),
+		Revokers:       fmtKeys(e.GetRevokers()),
+		HasSigningKey:  len(e.GetSK()) != 0,
+		SignatureValid: e.SigValid(),
+	}
+}
+
+// ParseDOT decodes a DOT blob, with or without the file type header
+func ParseDOT(blob []byte) (*DOTInfo, error) {
+	ro, err := parseWithHeader(blob, []int{objects.ROAccessDOT, objects.ROPermissionDOT}, objects.NewDOT, func(ro objects.RoutingObject) bool {
+		return ro.(*objects.DOT).SigValid()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return dotInfo(ro.(*objects.DOT)), nil
+}
+
+func dotInfo(d *objects.DOT) *DOTInfo {
+	rv := &DOTInfo{
+		Hash:           crypto.FmtHash(d.GetHash()),
+		From:           crypto.FmtKey(d.GetGiverVK()),
+		To:             crypto.FmtKey(d.GetReceiverVK()),
+		IsAccess:       d.IsAccess(),
+		TTL:            d.GetTTL(),
+		Contact:        d.GetContact(),
+		Comment:        d.GetComment(),
+		Created:        d.GetCreated(),
+		Expiry:         d.GetExpiry(),
+		Revokers:       fmtKeys(d.GetRevokers()),
+		SignatureValid: d.SigValid(),
+	}
+	if d.IsAccess() {
+		rv.URI = crypto.FmtKey(d.GetAccessURIMVK()) + "/" + d.GetAccessURISuffix()
+		rv.Permissions = d.GetPermString()
+	}
+	return rv
+}
+
+// ParseChain decodes a DOT chain blob, with or without the file type
+// header. The DOTs of an elaborated chain must be validly signed and match
+// the chain's hashes. A chain that is not elaborated only holds the DOT
+// hashes, which cannot be checked offline.
+func ParseChain(blob []byte) (*ChainInfo, error) {
+	ro, err := parseWithHeader(blob, []int{objects.ROAccessDChain, objects.ROPermissionDChain}, objects.NewDChain, func(ro objects.RoutingObject) bool {
+		dc := ro.(*objects.DChain)
+		return !dc.IsElaborated() || validDOTs(dc.NumHashes(), dc.GetDotHash, func(num int) signedDOT {
+			if d := dc.GetDOT(num); d != nil {
+				return d
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return chainInfo(ro.(*objects.DChain)), nil
+}
+
+// signedDOT is the part of a DOT that validDOTs checks
+type signedDOT interface {
+	SigValid() bool
+	GetHash() []byte
+}
+
+// validDOTs returns true if each of the n DOTs of a chain is present,
+// validly signed and has the hash the chain lists for it
+func validDOTs(n int, hash func(num int) []byte, dot func(num int) signedDOT) bool {
+	for i := 0; i < n; i++ {
+		d := dot(i)
+		if d == nil || !d.SigValid() || !bytes.Equal(d.GetHash(), hash(i)) {
+			return false
+		}
+	}
+	return true
+}
+
+func chainInfo(dc *objects.DChain) *ChainInfo {
+	rv := &ChainInfo{
+		Hash:       crypto.FmtHash(dc.GetChainHash()),
+		Elaborated: dc.IsElaborated(),
+	}
+	for i := 0; i < dc.NumHashes(); i++ {
+		rv.DOTHashes = append(rv.DOTHashes, crypto.FmtHash(dc.GetDotHash(i)))
+		if dc.IsElaborated() {
+			if d := dc.GetDOT(i); d != nil {
+				rv.DOTs = append(rv.DOTs, dotInfo(d))
+			}
+		}
+	}
+	return rv
+}
+
+// InspectFile decodes an entity, DOT or chain file, using its type header
+// to tell which. It returns an *EntityInfo, *DOTInfo or *ChainInfo.
+func InspectFile(filename string) (interface{}, error) {
+	contents, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	if len(contents) == 0 {
+		return nil, errors.New("empty file")
+	}
+	switch int(contents[0]) {
+	case objects.ROEntity, objects.ROEntityWKey:
+		return ParseEntity(contents)
+	case objects.ROAccessDOT, objects.ROPermissionDOT:
+		return ParseDOT(contents)
+	case objects.ROAccessDChain, objects.ROPermissionDChain:
+		return ParseChain(contents)
+	}
+	return nil, fmt.Errorf("unknown object type 0x%02x", contents[0])
+}
+
+// InspectJSON renders an *EntityInfo, *DOTInfo or *ChainInfo as indented JSON
+func InspectJSON(info interface{}) (string, error) {
+	b, err := json.MarshalIndent(info, "", "  ")
+	return string(b), err
+}
+
+func (e *EntityInfo) String() string {
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "Entity %s\n", e.VK)
+	fmt.Fprintf(b, "  Contact:   %s\n", e.Contact)
+	fmt.Fprintf(b, "  Comment:   %s\n", e.Comment)
+	fmt.Fprintf(b, "  Created:   %s\n", fmtTime(e.Created))
+	fmt.Fprintf(b, "  Expires:   %s\n", fmtTime(e.Expiry))
+	for _, r := range e.Revokers {
+		fmt.Fprintf(b, "  Revoker:   %s\n", r)
+	}
+	fmt.Fprintf(b, "  Signing key: %t  Signature valid: %t\n", e.HasSigningKey, e.SignatureValid)
+	return b.String()
+}
+
+func (d *DOTInfo) String() string {
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "DOT %s\n", d.Hash)
+	fmt.Fprintf(b, "  From:      %s\n", d.From)
+	fmt.Fprintf(b, "  To:        %s\n", d.To)
+	if d.IsAccess {
+		fmt.Fprintf(b, "  URI:       %s\n", d.URI)
+		fmt.Fprintf(b, "  Permissions: %s\n", d.Permissions)
+	} else {
+		fmt.Fprintf(b, "  Permission DOT\n")
+	}
+	fmt.Fprintf(b, "  TTL:       %d\n", d.TTL)
+	fmt.Fprintf(b, "  Contact:   %s\n", d.Contact)
+	fmt.Fprintf(b, "  Comment:   %s\n", d.Comment)
+	fmt.Fprintf(b, "  Created:   %s\n", fmtTime(d.Created))
+	fmt.Fprintf(b, "  Expires:   %s\n", fmtTime(d.Expiry))
+	for _, r := range d.Revokers {
+		fmt.Fprintf(b, "  Revoker:   %s\n", r)
+	}
+	fmt.Fprintf(b, "  Signature valid: %t\n", d.SignatureValid)
+	return b.String()
+}
+
+func (c *ChainInfo) String() string {
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "DChain %s (elaborated: %t)\n", c.Hash, c.Elaborated)
+	for i, h := range c.DOTHashes {
+		fmt.Fprintf(b, "  [%d] %s\n", i, h)
+	}
+	for _, d := range c.DOTs {
+		for _, l := range strings.Split(strings.TrimSuffix(d.String(), "\n"), "\n") {
+			b.WriteString("  " + l + "\n")
+		}
+	}
+	return b.String()
+}
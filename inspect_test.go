@@ -0,0 +1,106 @@
+package bw2bind
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/immesys/bw2/objects"
+)
+
+type fakeRO struct {
+	ronum   int
+	content []byte
+}
+
+func (f *fakeRO) GetRONum() int      { return f.ronum }
+func (f *fakeRO) GetContent() []byte { return f.content }
+
+func TestParseWithHeader(t *testing.T) {
+	// content is valid if it starts with "ok", except that ronum 2 rejects
+	// "okbad"
+	parse := func(ronum int, content []byte) (objects.RoutingObject, error) {
+		if len(content) < 2 {
+			return nil, errors.New("too short")
+		}
+		return &fakeRO{ronum, content}, nil
+	}
+	valid := func(ro objects.RoutingObject) bool {
+		f := ro.(*fakeRO)
+		if f.ronum == 2 && bytes.HasPrefix(f.content, []byte("okbad")) {
+			return false
+		}
+		return bytes.HasPrefix(f.content, []byte("ok"))
+	}
+	cases := []struct {
+		blob    string
+		ronum   int
+		content string
+		err     bool
+	}{
+		{"\x02ok", 2, "ok", false},
+		{"\x01ok", 1, "ok", false},
+		{"ok", 2, "ok", false},
+		{"\x01okbad", 1, "okbad", false},
+		// invalid with the header stripped and without it
+		{"\x02okbad", 0, "", true},
+		// a header byte that makes the rest invalid is not stripped
+		{"\x01xx", 0, "", true},
+		{"bad", 0, "", true},
+		{"\x01", 0, "", true},
+		{"", 0, "", true},
+	}
+	for _, c := range cases {
+		ro, err := parseWithHeader([]byte(c.blob), []int{2, 1}, parse, valid)
+		if c.err {
+			if err == nil {
+				t.Errorf("%q: expected an error, got ronum %d %q", c.blob, ro.GetRONum(), ro.GetContent())
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", c.blob, err)
+			continue
+		}
+		if ro.GetRONum() != c.ronum || string(ro.GetContent()) != c.content {
+			t.Errorf("%q: got ronum %d %q, expected %d %q", c.blob, ro.GetRONum(), ro.GetContent(), c.ronum, c.content)
+		}
+	}
+}
+
+type fakeDOT struct {
+	hash  string
+	valid bool
+}
+
+func (d *fakeDOT) SigValid() bool  { return d.valid }
+func (d *fakeDOT) GetHash() []byte { return []byte(d.hash) }
+
+func TestValidDOTs(t *testing.T) {
+	good1, good2, bad := &fakeDOT{"h1", true}, &fakeDOT{"h2", true}, &fakeDOT{"h3", false}
+	cases := []struct {
+		name   string
+		hashes []string
+		dots   []*fakeDOT
+		valid  bool
+	}{
+		{"valid DOTs", []string{"h1", "h2"}, []*fakeDOT{good1, good2}, true},
+		{"empty", nil, nil, true},
+		{"bad DOT signature", []string{"h1", "h3"}, []*fakeDOT{good1, bad}, false},
+		{"DOT swapped for another", []string{"h1", "h2"}, []*fakeDOT{good1, good1}, false},
+		{"DOT missing", []string{"h1", "h2"}, []*fakeDOT{good1, nil}, false},
+	}
+	for _, c := range cases {
+		got := validDOTs(len(c.hashes), func(num int) []byte {
+			return []byte(c.hashes[num])
+		}, func(num int) signedDOT {
+			if c.dots[num] == nil {
+				return nil
+			}
+			return c.dots[num]
+		})
+		if got != c.valid {
+			t.Errorf("%s: valid is %v, expected %v", c.name, got, c.valid)
+		}
+	}
+}
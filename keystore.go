@@ -0,0 +1,284 @@
+package bw2bind
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/immesys/bw2/crypto"
+	"github.com/immesys/bw2/objects"
+	"golang.org/x/crypto/scrypt"
+)
+
+// ErrKeystorePassphrase is returned when an entity in the keystore cannot
+// be decrypted with the keystore passphrase
+var ErrKeystorePassphrase = errors.New("wrong keystore passphrase")
+
+// ErrNotInKeystore is returned when the keystore has no entity with the
+// requested VK
+var ErrNotInKeystore = errors.New("entity not in keystore")
+
+const keystoreExt = ".ent"
+
+// Keystore keeps entities with their signing keys in a directory, one file
+// per entity. The entity blob is encrypted with a key derived from the
+// passphrase, while the VK, contact, comment and dates are kept in the
+// clear so the keystore can be listed and searched without it.
+type Keystore struct {
+	dir        string
+	passphrase []byte
+}
+
+// KeystoreEntry describes an entity held in a Keystore
+type KeystoreEntry struct {
+	VK      string     `json:"vk"`
+	Contact string     `json:"contact"`
+	Comment string     `json:"comment"`
+	Created *time.Time `json:"created,omitempty"`
+	Expiry  *time.Time `json:"expiry,omitempty"`
+}
+
+type keystoreFile struct {
+	KeystoreEntry
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// OpenKeystore opens the keystore in the given directory, creating it if
+// it does not exist
+func OpenKeystore(dir string, passphrase string) (*Keystore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &Keystore{dir: dir, passphrase: []byte(passphrase)}, nil
+}
+
+func (ks *Keystore) path(vk string) (string, error) {
+	if _, err := FromBase64(vk); err != nil {
+		return "", fmt.Errorf("invalid VK %q", vk)
+	}
+	return filepath.Join(ks.dir, vk+keystoreExt), nil
+}
+
+func (ks *Keystore) aead(salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key(ks.passphrase, salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+	blk, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(blk)
+}
+
+// Import adds an entity to the keystore. The blob is the binary
+// representation returned by CreateEntity; on-disk entity files made by
+// bw2 mke should use ImportFile instead.
+func (ks *Keystore) Import(blob []byte) (*KeystoreEntry, error) {
+	ro, err := objects.NewEntity(objects.ROEntityWKey, blob)
+	if err != nil {
+		return nil, err
+	}
+	ent := ro.(*objects.Entity)
+	rv := KeystoreEntry{
+		VK:      crypto.FmtKey(ent.GetVK()),
+		Contact: ent.GetContact(),
+		Comment: ent.GetComment(),
+		Created: ent.GetCreated(),
+		Expiry:  ent.GetExpiry(),
+	}
+	kf, err := ks.seal(rv, blob)
+	if err != nil {
+		return nil, err
+	}
+	if err := ks.save(kf); err != nil {
+		return nil, err
+	}
+	return &rv, nil
+}
+
+// seal encrypts the entity blob with a key derived from the passphrase and
+// a fresh salt. The VK is authenticated with it, so that the encrypted
+// blob cannot be moved to another entry.
+func (ks *Keystore) seal(ent KeystoreEntry, blob []byte) (*keystoreFile, error) {
+	kf := keystoreFile{KeystoreEntry: ent, Salt: make([]byte, 16)}
+	if _, err := rand.Read(kf.Salt); err != nil {
+		return nil, err
+	}
+	aead, err := ks.aead(kf.Salt)
+	if err != nil {
+		return nil, err
+	}
+	kf.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(kf.Nonce); err != nil {
+		return nil, err
+	}
+	kf.Ciphertext = aead.Seal(nil, kf.Nonce, blob, []byte(ent.VK))
+	return &kf, nil
+}
+
+// open decrypts the entity blob, failing with ErrKeystorePassphrase if
+// the passphrase is wrong or the file has been tampered with
+func (ks *Keystore) open(kf *keystoreFile) ([]byte, error) {
+	aead, err := ks.aead(kf.Salt)
+	if err != nil {
+		return nil, err
+	}
+	if len(kf.Nonce) != aead.NonceSize() {
+		return nil, ErrKeystorePassphrase
+	}
+	blob, err := aead.Open(nil, kf.Nonce, kf.Ciphertext, []byte(kf.VK))
+	if err != nil {
+		return nil, ErrKeystorePassphrase
+	}
+	return blob, nil
+}
+
+func (ks *Keystore) save(kf *keystoreFile) error {
+	contents, err := json.MarshalIndent(kf, "", "  ")
+	if err != nil {
+		return err
+	}
+	fname, err := ks.path(kf.VK)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fname, contents, 0600)
+}
+
+// ImportFile adds an entity file, as made by bw2 mke, to the keystore
+func (ks *Keystore) ImportFile(filename string) (*KeystoreEntry, error) {
+	contents, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	if len(contents) < 2 {
+		return nil, errors.New("entity file too short")
+	}
+	return ks.Import(contents[1:])
+}
+
+func (ks *Keystore) load(vk string) (*keystoreFile, error) {
+	fname, err := ks.path(vk)
+	if err != nil {
+		return nil, err
+	}
+	contents, err := ioutil.ReadFile(fname)
+	if os.IsNotExist(err) {
+		return nil, ErrNotInKeystore
+	}
+	if err != nil {
+		return nil, err
+	}
+	kf := keystoreFile{}
+	if err := json.Unmarshal(contents, &kf); err != nil {
+		return nil, fmt.Errorf("corrupt keystore file %s: %v", fname, err)
+	}
+	return &kf, nil
+}
+
+// Get decrypts and returns the binary representation of the entity,
+// suitable for SetEntity
+func (ks *Keystore) Get(vk string) ([]byte, error) {
+	kf, err := ks.load(vk)
+	if err != nil {
+		return nil, err
+	}
+	return ks.open(kf)
+}
+
+// Export writes the entity to a file in the same format as bw2 mke, so
+// that it can be used with SetEntityFile
+func (ks *Keystore) Export(vk string, filename string) error {
+	blob, err := ks.Get(vk)
+	if err != nil {
+		return err
+	}
+	contents := append([]byte{objects.ROEntityWKey}, blob...)
+	return ioutil.WriteFile(filename, contents, 0600)
+}
+
+// Remove deletes the entity from the keystore
+func (ks *Keystore) Remove(vk string) error {
+	fname, err := ks.path(vk)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(fname)
+	if os.IsNotExist(err) {
+		return ErrNotInKeystore
+	}
+	return err
+}
+
+// List returns every entity in the keystore, ordered by contact and then VK
+func (ks *Keystore) List() ([]*KeystoreEntry, error) {
+	fnames, err := filepath.Glob(filepath.Join(ks.dir, "*"+keystoreExt))
+	if err != nil {
+		return nil, err
+	}
+	rv := []*KeystoreEntry{}
+	for _, fname := range fnames {
+		kf, err := ks.load(strings.TrimSuffix(filepath.Base(fname), keystoreExt))
+		if err != nil {
+			return nil, err
+		}
+		ent := kf.KeystoreEntry
+		rv = append(rv, &ent)
+	}
+	sort.Slice(rv, func(i, j int) bool {
+		if rv[i].Contact != rv[j].Contact {
+			return rv[i].Contact < rv[j].Contact
+		}
+		return rv[i].VK < rv[j].VK
+	})
+	return rv, nil
+}
+
+// Find returns the entities whose VK starts with the query, or whose
+// contact or comment contain it, ignoring case
+func (ks *Keystore) Find(query string) ([]*KeystoreEntry, error) {
+	all, err := ks.List()
+	if err != nil {
+		return nil, err
+	}
+	q := strings.ToLower(query)
+	rv := []*KeystoreEntry{}
+	for _, ent := range all {
+		if strings.HasPrefix(ent.VK, query) ||
+			strings.Contains(strings.ToLower(ent.Contact), q) ||
+			strings.Contains(strings.ToLower(ent.Comment), q) {
+			rv = append(rv, ent)
+		}
+	}
+	return rv, nil
+}
+
+// UseKeystore sets the keystore used by SetEntityFromKeystore
+func (cl *BW2Client) UseKeystore(ks *Keystore) {
+	cl.ks = ks
+}
+
+// SetEntityFromKeystore is the same as SetEntity but loads the entity with
+// the given VK from the keystore set with UseKeystore
+func (cl *BW2Client) SetEntityFromKeystore(vk string) (string, error) {
+	if cl.ks == nil {
+		return "", errors.New("no keystore set, call UseKeystore first")
+	}
+	blob, err := cl.ks.Get(vk)
+	if err != nil {
+		return "", err
+	}
+	return cl.SetEntity(blob)
+}
@@ -0,0 +1,140 @@
+package bw2bind
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/immesys/bw2/objects"
+)
+
+func testVK(b byte) string {
+	return base64.URLEncoding.EncodeToString(bytes.Repeat([]byte{b}, 32))
+}
+
+// storeEntity seals and saves a blob as if it had been imported
+func storeEntity(t *testing.T, ks *Keystore, ent KeystoreEntry, blob []byte) {
+	kf, err := ks.seal(ent, blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.save(kf); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKeystoreRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	ks, err := OpenKeystore(dir, "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob := []byte("signing key and entity")
+	storeEntity(t, ks, KeystoreEntry{VK: testVK(1), Contact: "Alice", Comment: "laptop"}, blob)
+	storeEntity(t, ks, KeystoreEntry{VK: testVK(2), Contact: "Bob", Comment: "router"}, []byte("other"))
+
+	got, err := ks.Get(testVK(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, blob) {
+		t.Fatalf("Get returned %q, expected %q", got, blob)
+	}
+	contents, err := ioutil.ReadFile(filepath.Join(dir, testVK(1)+keystoreExt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(contents, blob) {
+		t.Error("the entity blob is stored in the clear")
+	}
+
+	all, err := ks.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 || all[0].Contact != "Alice" || all[1].Contact != "Bob" {
+		t.Errorf("List returned %+v", all)
+	}
+	found, err := ks.Find("ROUT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 || found[0].VK != testVK(2) {
+		t.Errorf("Find returned %+v", found)
+	}
+
+	exported := filepath.Join(dir, "alice.key")
+	if err := ks.Export(testVK(1), exported); err != nil {
+		t.Fatal(err)
+	}
+	contents, err = ioutil.ReadFile(exported)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contents[0] != objects.ROEntityWKey || !bytes.Equal(contents[1:], blob) {
+		t.Errorf("Export wrote %q", contents)
+	}
+
+	if err := ks.Remove(testVK(1)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ks.Get(testVK(1)); err != ErrNotInKeystore {
+		t.Errorf("Get after Remove gave %v", err)
+	}
+	if err := ks.Remove(testVK(1)); err != ErrNotInKeystore {
+		t.Errorf("second Remove gave %v", err)
+	}
+}
+
+func TestKeystoreWrongPassphrase(t *testing.T) {
+	dir := t.TempDir()
+	ks, err := OpenKeystore(dir, "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	storeEntity(t, ks, KeystoreEntry{VK: testVK(1)}, []byte("secret"))
+	other, err := OpenKeystore(dir, "hunter3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.Get(testVK(1)); err != ErrKeystorePassphrase {
+		t.Errorf("Get with the wrong passphrase gave %v", err)
+	}
+	// listing does not need the passphrase
+	if all, err := other.List(); err != nil || len(all) != 1 {
+		t.Errorf("List with the wrong passphrase gave %v, %v", all, err)
+	}
+}
+
+func TestKeystoreTampered(t *testing.T) {
+	ks, err := OpenKeystore(t.TempDir(), "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		tamper func(kf *keystoreFile)
+	}{
+		{"ciphertext", func(kf *keystoreFile) { kf.Ciphertext[0] ^= 1 }},
+		{"truncated ciphertext", func(kf *keystoreFile) { kf.Ciphertext = kf.Ciphertext[:len(kf.Ciphertext)-1] }},
+		{"nonce", func(kf *keystoreFile) { kf.Nonce[0] ^= 1 }},
+		{"short nonce", func(kf *keystoreFile) { kf.Nonce = kf.Nonce[:4] }},
+		{"salt", func(kf *keystoreFile) { kf.Salt[0] ^= 1 }},
+		{"moved to another VK", func(kf *keystoreFile) { kf.VK = testVK(2) }},
+	}
+	for _, tt := range tests {
+		kf, err := ks.seal(KeystoreEntry{VK: testVK(1)}, []byte("secret"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tt.tamper(kf)
+		if err := ks.save(kf); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ks.Get(kf.VK); err != ErrKeystorePassphrase {
+			t.Errorf("%s: Get gave %v", tt.name, err)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package bw2bind
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	log "github.com/cihub/seelog"
+)
+
+// ErrServiceClosed is returned when subscribing on a Service or
+// ServiceClient that has been closed
+var ErrServiceClosed = errors.New("service closed")
+
+// lifecycle owns the subscriptions and handler goroutines of a Service or
+// ServiceClient so that they can be torn down together
+type lifecycle struct {
+	cl           *BW2Client
+	mu           sync.Mutex
+	handles      map[string]bool
+	wg           sync.WaitGroup
+	done         chan struct{}
+	stopOnce     sync.Once
+	stopErr      error
+	errorHandler func(error)
+}
+
+func newLifecycle(cl *BW2Client) *lifecycle {
+	return &lifecycle{
+		cl:      cl,
+		handles: make(map[string]bool),
+		done:    make(chan struct{}),
+	}
+}
+
+func (lc *lifecycle) setErrorHandler(f func(error)) {
+	lc.mu.Lock()
+	lc.errorHandler = f
+	lc.mu.Unlock()
+}
+
+// report passes err to the error handler, or if there is none, to the
+// given fallback
+func (lc *lifecycle) report(err error, fallback func(error)) {
+	lc.mu.Lock()
+	eh := lc.errorHandler
+	lc.mu.Unlock()
+	if eh != nil {
+		eh(err)
+	} else {
+		fallback(err)
+	}
+}
+
+func (lc *lifecycle) handlerError(err error) {
+	lc.report(err, func(err error) {
+		log.Warn("Service handler error: ", err)
+	})
+}
+
+func (lc *lifecycle) closed() bool {
+	select {
+	case <-lc.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// subscribe subscribes with the given params and dispatches every message
+// to cb until the subscription ends or the lifecycle is closed. Panics in
+// cb are recovered and reported to the error handler.
+func (lc *lifecycle) subscribe(p *SubscribeParams, opts *DispatchOptions, cb func(*SimpleMessage)) (string, error) {
+	if lc.closed() {
+		return "", ErrServiceClosed
+	}
+	rc, handle, err := lc.cl.SubscribeH(p)
+	if err != nil {
+		return "", err
+	}
+	lc.mu.Lock()
+	if lc.closed() {
+		lc.mu.Unlock()
+		lc.cl.Unsubscribe(handle)
+		return "", ErrServiceClosed
+	}
+	lc.handles[handle] = true
+	lc.wg.Add(1)
+	lc.mu.Unlock()
+	d := newDispatcher(p.URI, opts, cb, lc.handlerError)
+	go func() {
+		defer lc.wg.Done()
+		defer d.close()
+		for {
+			select {
+			case <-lc.done:
+				go func() {
+					for _ = range rc {
+					}
+				}()
+				return
+			case sm, ok := <-rc:
+				if !ok {
+					return
+				}
+				d.dispatch(sm)
+			}
+		}
+	}()
+	return handle, nil
+}
+
+func (lc *lifecycle) unsubscribe(handle string) error {
+	lc.mu.Lock()
+	delete(lc.handles, handle)
+	lc.mu.Unlock()
+	return lc.cl.Unsubscribe(handle)
+}
+
+// stop unsubscribes everything without waiting for in-flight handlers.
+// Only the first call does anything, later calls return its result.
+func (lc *lifecycle) stop() error {
+	lc.stopOnce.Do(func() {
+		lc.mu.Lock()
+		close(lc.done)
+		handles := lc.handles
+		lc.handles = make(map[string]bool)
+		lc.mu.Unlock()
+		for h := range handles {
+			if err := lc.cl.Unsubscribe(h); err != nil && lc.stopErr == nil {
+				lc.stopErr = err
+			}
+		}
+	})
+	return lc.stopErr
+}
+
+// close stops the lifecycle and waits for in-flight handlers to finish
+func (lc *lifecycle) close() error {
+	err := lc.stop()
+	lc.wg.Wait()
+	return err
+}
+
+func (lc *lifecycle) run(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+	case <-lc.done:
+	}
+	return lc.close()
+}
+
+// Run blocks until the context is cancelled or the service is stopped or
+// closed, and then closes the service
+func (s *Service) Run(ctx context.Context) error {
+	return s.life.run(ctx)
+}
+
+// Close stops the heartbeat, unsubscribes all slots and waits for any
+// in-flight slot handlers to return. A slot handler must not call Close,
+// as it would wait for itself. It can call Stop or cancel the context
+// given to Run instead.
+func (s *Service) Close() error {
+	return s.life.close()
+}
+
+// Stop is Close without waiting for in-flight slot handlers, so that a
+// slot handler can stop its own service. Close can be called afterwards to
+// wait for them.
+func (s *Service) Stop() error {
+	return s.life.stop()
+}
+
+// Unsubscribe cancels a slot subscription made with SubscribeSlotH
+func (s *Service) Unsubscribe(handle string) error {
+	return s.life.unsubscribe(handle)
+}
+
+// Run blocks until the context is cancelled or the client is stopped or
+// closed, and then closes the client
+func (sc *ServiceClient) Run(ctx context.Context) error {
+	return sc.life.run(ctx)
+}
+
+// Close unsubscribes all signals and waits for any in-flight signal
+// handlers to return. A signal handler must not call Close, as it would
+// wait for itself. It can call Stop or cancel the context given to Run
+// instead.
+func (sc *ServiceClient) Close() error {
+	return sc.life.close()
+}
+
+// Stop is Close without waiting for in-flight signal handlers, so that a
+// signal handler can stop its own client. Close can be called afterwards
+// to wait for them.
+func (sc *ServiceClient) Stop() error {
+	return sc.life.stop()
+}
+
+// Unsubscribe cancels a signal subscription made with SubscribeSignalH
+func (sc *ServiceClient) Unsubscribe(handle string) error {
+	return sc.life.unsubscribe(handle)
+}
+
+// SetErrorHandler sets the function that handler panics and decode
+// errors are reported to
+func (sc *ServiceClient) SetErrorHandler(f func(error)) {
+	sc.life.setErrorHandler(f)
+}
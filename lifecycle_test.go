@@ -0,0 +1,97 @@
+package bw2bind
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// subscribingRouter accepts subscriptions with handles h0, h1, ... and
+// publishes one message on each
+func subscribingRouter(fr *frame, send func(*frame)) {
+	switch fr.Cmd {
+	case cmdSubscribe:
+		uri, _ := fr.GetFirstHeader("uri")
+		send(okay(fr, "handle", "h"+strconv.Itoa(fr.SeqNo)))
+		send(result(fr, "uri", uri, "from", "sender"))
+	case cmdUnsubscribe:
+		send(okay(fr))
+	}
+}
+
+func TestStopFromHandler(t *testing.T) {
+	router, cl := newFakeRouter(t, subscribingRouter)
+	sc := cl.NewServiceClient("ns/svc", "s.test")
+	ifc := sc.AddInterface("dev", "i.test")
+	stopped := make(chan error, 1)
+	if err := ifc.SubscribeSignal("sig", func(sm *SimpleMessage) {
+		stopped <- sc.Stop()
+	}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-stopped:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stop from a handler did not return")
+	}
+	within(t, 5*time.Second, "Close after Stop from a handler", func() {
+		if err := sc.Close(); err != nil {
+			t.Error(err)
+		}
+	})
+	if n := len(router.received(cmdUnsubscribe)); n != 1 {
+		t.Errorf("expected one unsubscribe, got %d", n)
+	}
+}
+
+func TestRunCancelledFromHandler(t *testing.T) {
+	router, cl := newFakeRouter(t, subscribingRouter)
+	sc := cl.NewServiceClient("ns/svc", "s.test")
+	ifc := sc.AddInterface("dev", "i.test")
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := ifc.SubscribeSignal("sig", func(sm *SimpleMessage) {
+		cancel()
+	}); err != nil {
+		t.Fatal(err)
+	}
+	within(t, 5*time.Second, "Run", func() {
+		if err := sc.Run(ctx); err != nil {
+			t.Error(err)
+		}
+	})
+	if n := len(router.received(cmdUnsubscribe)); n != 1 {
+		t.Errorf("expected one unsubscribe, got %d", n)
+	}
+}
+
+func TestCloseWaitsForHandlers(t *testing.T) {
+	_, cl := newFakeRouter(t, subscribingRouter)
+	sc := cl.NewServiceClient("ns/svc", "s.test")
+	ifc := sc.AddInterface("dev", "i.test")
+	started := make(chan struct{})
+	release := make(chan struct{})
+	finished := false
+	if err := ifc.SubscribeSignal("sig", func(sm *SimpleMessage) {
+		close(started)
+		<-release
+		finished = true
+	}); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		close(release)
+	}()
+	within(t, 5*time.Second, "Close", func() { sc.Close() })
+	if !finished {
+		t.Error("Close returned before the handler finished")
+	}
+	if _, err := ifc.SubscribeSignalH("sig", func(*SimpleMessage) {}); err != ErrServiceClosed {
+		t.Errorf("subscribing after Close gave %v", err)
+	}
+}
@@ -2,6 +2,7 @@ package bw2bind
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"math/big"
 	"os"
@@ -10,6 +11,7 @@ import (
 	"sync"
 	"time"
 
+	log "github.com/cihub/seelog"
 	"gopkg.in/vmihailenco/msgpack.v2"
 
 	"github.com/immesys/bw2/crypto"
@@ -350,7 +352,7 @@ func (cl *BW2Client) ResolveRegistry(key string) (ro objects.RoutingObject, vali
 		validity = StateUnknown
 		return
 	default:
-		panic(valid)
+		return nil, StateError, fmt.Errorf("unknown validity string %q", valid)
 	}
 }
 func (cl *BW2Client) FindDOTsFromVK(vk string) ([]*objects.DOT, []RegistryValidity, error) {
@@ -369,7 +371,11 @@ func (cl *BW2Client) FindDOTsFromVK(vk string) ([]*objects.DOT, []RegistryValidi
 			return nil, nil, err
 		}
 		if po.PONum == PONumString {
-			switch strings.ToLower(rpo.(TextPayloadObject).Value()) {
+			tpo, ok := rpo.(TextPayloadObject)
+			if !ok {
+				return nil, nil, errors.New("bad validity payload object")
+			}
+			switch strings.ToLower(tpo.Value()) {
 			case "valid":
 				rvv = append(rvv, StateValid)
 			case "expired":
@@ -379,7 +385,7 @@ func (cl *BW2Client) FindDOTsFromVK(vk string) ([]*objects.DOT, []RegistryValidi
 			case "unknown":
 				rvv = append(rvv, StateUnknown)
 			default:
-				panic("unknown validity string: " + rpo.(TextPayloadObject).Value())
+				return nil, nil, fmt.Errorf("unknown validity string %q", tpo.Value())
 			}
 		}
 		if po.PONum == PONumROAccessDOT {
@@ -390,6 +396,9 @@ func (cl *BW2Client) FindDOTsFromVK(vk string) ([]*objects.DOT, []RegistryValidi
 			rvd = append(rvd, doti.(*objects.DOT))
 		}
 	}
+	if len(rvd) != len(rvv) {
+		return nil, nil, fmt.Errorf("router returned %d DOTs but %d validities", len(rvd), len(rvv))
+	}
 	return rvd, rvv, nil
 }
 
@@ -400,6 +409,29 @@ type BalanceInfo struct {
 	Int     *big.Int
 }
 
+// parseBalanceInfo parses an "addr,decimal,human" balance payload
+func parseBalanceInfo(po []byte) (*BalanceInfo, error) {
+	parts := strings.SplitN(string(po), ",", 3)
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("bad balance %q", string(po))
+	}
+	i, ok := new(big.Int).SetString(parts[1], 10)
+	if !ok {
+		return nil, fmt.Errorf("bad balance value %q", parts[1])
+	}
+	return &BalanceInfo{
+		Addr:    parts[0],
+		Decimal: parts[1],
+		Human:   parts[2],
+		Int:     i,
+	}, nil
+}
+
+// Amount returns the balance as an Amount
+func (bi *BalanceInfo) Amount() Amount {
+	return AmountFromWei(bi.Int)
+}
+
 func (cl *BW2Client) EntityBalances() ([]*BalanceInfo, error) {
 	seqno := cl.GetSeqNo()
 	req := createFrame(cmdEntityBalances, seqno)
@@ -410,23 +442,17 @@ func (cl *BW2Client) EntityBalances() ([]*BalanceInfo, error) {
 	rv := make([]*BalanceInfo, 0, 16)
 	for _, poe := range fr.POs {
 		if poe.PONum == PONumAccountBalance {
-			parts := strings.Split(string(poe.PO), ",")
-			i := big.NewInt(0)
-			i, _ = i.SetString(parts[1], 10)
-			rv = append(rv, &BalanceInfo{
-				Addr:    parts[0],
-				Decimal: parts[1],
-				Human:   parts[2],
-				Int:     i,
-			})
+			bi, err := parseBalanceInfo(poe.PO)
+			if err != nil {
+				return nil, err
+			}
+			rv = append(rv, bi)
 		}
 	}
 	return rv, nil
 }
 func (cl *BW2Client) AddressBalance(addr string) (*BalanceInfo, error) {
-	if addr[0:2] == "0x" {
-		addr = addr[2:]
-	}
+	addr = strings.TrimPrefix(addr, "0x")
 	if len(addr) != 40 {
 		return nil, fmt.Errorf("Address must be 40 hex characters")
 	}
@@ -437,17 +463,10 @@ func (cl *BW2Client) AddressBalance(addr string) (*BalanceInfo, error) {
 	if er := fr.MustResponse(); er != nil {
 		return nil, er
 	}
-	poe := fr.POs[0]
-	parts := strings.Split(string(poe.PO), ",")
-	i := big.NewInt(0)
-	i, _ = i.SetString(parts[1], 10)
-	rv := &BalanceInfo{
-		Addr:    parts[0],
-		Decimal: parts[1],
-		Human:   parts[2],
-		Int:     i,
+	if len(fr.POs) == 0 {
+		return nil, errors.New("bad response")
 	}
-	return rv, nil
+	return parseBalanceInfo(fr.POs[0].PO)
 }
 
 type BCIP struct {
@@ -696,6 +715,9 @@ func (cl *BW2Client) Unsubscribe(handle string) error {
 }
 func (cl *BW2Client) CreateView(expression expr.M) (*View, error) {
 	mp, err := msgpack.Marshal(expression)
+	if err != nil {
+		return nil, err
+	}
 	seqno := cl.GetSeqNo()
 	req := createFrame(cmdMakeView, seqno)
 	req.AddHeaderB("msgpack", mp)
@@ -709,23 +731,139 @@ func (cl *BW2Client) CreateView(expression expr.M) (*View, error) {
 	if err != nil {
 		return nil, err
 	}
-	rv := &View{vid: int(vid), cl: cl}
+	handle, _ := fr.GetFirstHeader("handle")
+	rv := &View{vid: int(vid), cl: cl, seqno: seqno, handle: handle, done: make(chan struct{})}
 	go func() {
 		for _ = range rc {
 			rv.cbmu.Lock()
-			for _, cb := range rv.cbz {
-				cb()
+			cbz := make([]func(), len(rv.cbz))
+			copy(cbz, rv.cbz)
+			rv.cbmu.Unlock()
+			for _, cb := range cbz {
+				rv.callOnChange(cb)
 			}
 		}
 	}()
 	return rv, nil
 }
+
+// ErrViewClosed is returned by View operations after View.Close
+var ErrViewClosed = errors.New("view closed")
+
+// ErrViewHandleMissing is returned by View.Close if the router did not
+// give a handle for the view or one of its subscriptions, so it could not
+// be torn down on the router. It stays there until the client disconnects.
+var ErrViewHandleMissing = errors.New("router gave no handle, view remains on the router")
+
+func (v *View) callOnChange(cb func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Error("panic in view change callback: ", r)
+		}
+	}()
+	cb()
+}
+
+// Close stops the view from receiving changes, cancels every SubSlot,
+// SubSignal and InterfaceDescriptor.SubscribeSignal subscription made
+// through it (closing their channels) and tears down the view on the
+// router. Subscriptions that were already cancelled on the router are
+// skipped. Everything is closed locally even if
+// it fails, and ErrViewHandleMissing is returned if the router never gave
+// a handle to tear the view down with. Calling Close more than once is
+// harmless.
+func (v *View) Close() error {
+	v.cbmu.Lock()
+	if v.closed {
+		v.cbmu.Unlock()
+		return nil
+	}
+	v.closed = true
+	v.cbz = nil
+	subs := v.subs
+	v.subs = nil
+	close(v.done)
+	v.cbmu.Unlock()
+	var rv error
+	unsubscribe := func(handle string) {
+		err := ErrViewHandleMissing
+		if handle != "" {
+			err = v.cl.Unsubscribe(handle)
+		}
+		if err != nil && rv == nil {
+			rv = err
+		}
+	}
+	for _, sub := range subs {
+		if sub.handle == "" {
+			unsubscribe("")
+		} else if err := v.cl.Unsubscribe(sub.handle); err != nil {
+			// it may already have been cancelled with BW2Client.Unsubscribe
+			log.Info("could not unsubscribe view subscription: ", err)
+		}
+		v.cl.closeSeqno(sub.seqno)
+	}
+	unsubscribe(v.handle)
+	v.cl.closeSeqno(v.seqno)
+	return rv
+}
+
+// CreateViewExpr validates the expression built with the expr package and
+// creates a view from it
+func (cl *BW2Client) CreateViewExpr(e expr.Expr) (*View, error) {
+	if err := e.Validate(); err != nil {
+		return nil, err
+	}
+	return cl.CreateView(e.M())
+}
+
+// unsubscribe cancels one of the view's subscriptions and closes its
+// channel
+func (v *View) unsubscribe(handle string) error {
+	v.cbmu.Lock()
+	var sub *viewSub
+	for i := range v.subs {
+		if v.subs[i].handle == handle {
+			s := v.subs[i]
+			sub = &s
+			v.subs = append(v.subs[:i], v.subs[i+1:]...)
+			break
+		}
+	}
+	closed := v.closed
+	v.cbmu.Unlock()
+	if sub == nil {
+		if closed {
+			return ErrViewClosed
+		}
+		return fmt.Errorf("%q is not a subscription of this view", handle)
+	}
+	defer v.cl.closeSeqno(sub.seqno)
+	if handle == "" {
+		return ErrViewHandleMissing
+	}
+	return v.cl.Unsubscribe(handle)
+}
+
+// OnChange registers a function to be called whenever the set of
+// interfaces matching the view changes. Callbacks are called one at a
+// time from a single goroutine and may safely call other View methods.
 func (v *View) OnChange(f func()) {
 	v.cbmu.Lock()
-	v.cbz = append(v.cbz, f)
+	if !v.closed {
+		v.cbz = append(v.cbz, f)
+	}
 	v.cbmu.Unlock()
 }
+func (v *View) isClosed() bool {
+	v.cbmu.Lock()
+	defer v.cbmu.Unlock()
+	return v.closed
+}
 func (v *View) List() ([]*InterfaceDescriptor, error) {
+	if v.isClosed() {
+		return nil, ErrViewClosed
+	}
 	rv := []*InterfaceDescriptor{}
 	seqno := v.cl.GetSeqNo()
 	req := createFrame(cmdListView, seqno)
@@ -742,15 +880,47 @@ func (v *View) List() ([]*InterfaceDescriptor, error) {
 		if err != nil {
 			return nil, err
 		}
+		ifd.v = v
 		rv = append(rv, &ifd)
 	}
 	return rv, nil
 }
+
+// Descriptor converts the interface descriptor into the form used by
+// expr.Evaluate
+func (ifd *InterfaceDescriptor) Descriptor() *expr.Descriptor {
+	return &expr.Descriptor{
+		URI:       ifd.URI,
+		Interface: ifd.Interface,
+		Service:   ifd.Service,
+		Namespace: ifd.Namespace,
+		Metadata:  ifd.Metadata,
+	}
+}
+
+// FilterInterfaces returns the interfaces that match the expression,
+// evaluated locally. It is useful for narrowing the results of View.List.
+func FilterInterfaces(ifs []*InterfaceDescriptor, e expr.Expr) []*InterfaceDescriptor {
+	rv := []*InterfaceDescriptor{}
+	for _, ifd := range ifs {
+		if expr.Matches(e, ifd.Descriptor()) {
+			rv = append(rv, ifd)
+		}
+	}
+	return rv
+}
 func chToCB(ch chan *SimpleMessage, cb func(sm *SimpleMessage)) {
+	chToCBWith(ch, nil, cb)
+}
+func chToCBWith(ch chan *SimpleMessage, opts *DispatchOptions, cb func(sm *SimpleMessage)) {
+	d := newDispatcher("view subscription", opts, cb, func(err error) {
+		log.Error(err)
+	})
 	go func() {
 		for m := range ch {
-			cb(m)
+			d.dispatch(m)
 		}
+		d.close()
 	}()
 }
 func (v *View) PubSlot(iface, slot string, poz []PayloadObject) error {
@@ -760,6 +930,9 @@ func (v *View) PubSignal(iface, signal string, poz []PayloadObject) error {
 	return v.pubSigSlot(iface, "signal", signal, poz)
 }
 func (v *View) pubSigSlot(iface, t, sigslot string, poz []PayloadObject) error {
+	if v.isClosed() {
+		return ErrViewClosed
+	}
 	seqno := v.cl.GetSeqNo()
 	req := createFrame(cmdPublishView, seqno)
 	req.AddHeader("id", strconv.Itoa(v.vid))
@@ -786,6 +959,17 @@ func (v *View) SubSlotF(iface, slot string, cb func(sm *SimpleMessage)) error {
 	chToCB(rv, cb)
 	return err
 }
+
+// SubSlotFWith is like SubSlotF but lets you choose how messages are
+// dispatched to the callback
+func (v *View) SubSlotFWith(iface, slot string, opts *DispatchOptions, cb func(sm *SimpleMessage)) error {
+	rv, err := v.SubSlot(iface, slot)
+	if err != nil {
+		return err
+	}
+	chToCBWith(rv, opts, cb)
+	return nil
+}
 func (v *View) SubSlotFOrExit(iface, slot string, cb func(sm *SimpleMessage)) {
 	rv, err := v.SubSlot(iface, slot)
 	if err != nil {
@@ -810,6 +994,17 @@ func (v *View) SubSignalF(iface, signal string, cb func(sm *SimpleMessage)) erro
 	chToCB(rv, cb)
 	return err
 }
+
+// SubSignalFWith is like SubSignalF but lets you choose how messages are
+// dispatched to the callback
+func (v *View) SubSignalFWith(iface, signal string, opts *DispatchOptions, cb func(sm *SimpleMessage)) error {
+	rv, err := v.SubSignal(iface, signal)
+	if err != nil {
+		return err
+	}
+	chToCBWith(rv, opts, cb)
+	return nil
+}
 func (v *View) SubSignalFOrExit(iface, signal string, cb func(sm *SimpleMessage)) {
 	rv, err := v.SubSignal(iface, signal)
 	chToCB(rv, cb)
@@ -819,6 +1014,9 @@ func (v *View) SubSignalFOrExit(iface, signal string, cb func(sm *SimpleMessage)
 	}
 }
 func (v *View) subSigSlot(iface, t, sigslot string) (chan *SimpleMessage, error) {
+	if v.isClosed() {
+		return nil, ErrViewClosed
+	}
 	seqno := v.cl.GetSeqNo()
 	req := createFrame(cmdSubscribeView, seqno)
 	req.AddHeader("id", strconv.Itoa(v.vid))
@@ -831,6 +1029,18 @@ func (v *View) subSigSlot(iface, t, sigslot string) (chan *SimpleMessage, error)
 	if err != nil {
 		return nil, err
 	}
+	handle, _ := fr.GetFirstHeader("handle")
+	v.cbmu.Lock()
+	if v.closed {
+		v.cbmu.Unlock()
+		if handle != "" {
+			v.cl.Unsubscribe(handle)
+		}
+		v.cl.closeSeqno(seqno)
+		return nil, ErrViewClosed
+	}
+	v.subs = append(v.subs, viewSub{seqno: seqno, handle: handle})
+	v.cbmu.Unlock()
 	//Generate converted output channel
 	rv := make(chan *SimpleMessage, 10)
 	go func() {
@@ -0,0 +1,247 @@
+package bw2bind
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/immesys/bw2/objects"
+)
+
+// ResolverParams sets how long a CachingResolver keeps each kind of result.
+// Zero durations take the defaults below, negative durations disable
+// caching of that kind of result.
+type ResolverParams struct {
+	// Objects that are valid
+	ValidTTL time.Duration
+	// Objects that are expired or revoked. These do not become valid again,
+	// so they can be kept for a long time
+	InvalidTTL time.Duration
+	// Objects whose validity the router could not determine
+	UnknownTTL time.Duration
+	// Keys that are not in the registry and aliases that resolve to zero
+	NegativeTTL time.Duration
+	// Aliases that resolve to a value
+	AliasTTL time.Duration
+	// The most results kept, DefaultMaxEntries if zero. When the cache is
+	// full, expired results are swept and then those closest to expiry
+	// are evicted.
+	MaxEntries int
+}
+
+const (
+	DefaultValidTTL    = 5 * time.Minute
+	DefaultInvalidTTL  = time.Hour
+	DefaultUnknownTTL  = 10 * time.Second
+	DefaultNegativeTTL = 30 * time.Second
+	DefaultAliasTTL    = 10 * time.Minute
+	DefaultMaxEntries  = 10000
+)
+
+func (p *ResolverParams) normalize() {
+	def := func(d *time.Duration, v time.Duration) {
+		if *d == 0 {
+			*d = v
+		}
+	}
+	def(&p.ValidTTL, DefaultValidTTL)
+	def(&p.InvalidTTL, DefaultInvalidTTL)
+	def(&p.UnknownTTL, DefaultUnknownTTL)
+	def(&p.NegativeTTL, DefaultNegativeTTL)
+	def(&p.AliasTTL, DefaultAliasTTL)
+	if p.MaxEntries <= 0 {
+		p.MaxEntries = DefaultMaxEntries
+	}
+}
+
+// resolution is the result of any of the resolve calls
+type resolution struct {
+	ro       objects.RoutingObject
+	validity RegistryValidity
+	data     []byte
+	str      string
+	zero     bool
+	err      error
+}
+
+type cacheEntry struct {
+	res     *resolution
+	expires time.Time
+}
+
+type inflight struct {
+	wg  sync.WaitGroup
+	res *resolution
+}
+
+// CachingResolver sits in front of the registry and alias resolution calls
+// of a BW2Client and caches their results for a time that depends on the
+// result. Concurrent lookups of the same key share one router request.
+// Errors are never cached.
+type CachingResolver struct {
+	cl      *BW2Client
+	p       ResolverParams
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+	calls   map[string]*inflight
+}
+
+// NewCachingResolver returns a resolver that caches lookups made through cl
+func (cl *BW2Client) NewCachingResolver(p *ResolverParams) *CachingResolver {
+	np := ResolverParams{}
+	if p != nil {
+		np = *p
+	}
+	np.normalize()
+	return &CachingResolver{
+		cl:      cl,
+		p:       np,
+		entries: make(map[string]*cacheEntry),
+		calls:   make(map[string]*inflight),
+	}
+}
+
+// do returns the cached result for ck, or calls fn once for all concurrent
+// callers and caches the result for the TTL that ttl returns
+func (r *CachingResolver) do(ck string, fn func() *resolution, ttl func(*resolution) time.Duration) *resolution {
+	r.mu.Lock()
+	if e, ok := r.entries[ck]; ok {
+		if time.Now().Before(e.expires) {
+			r.mu.Unlock()
+			return e.res
+		}
+		delete(r.entries, ck)
+	}
+	if c, ok := r.calls[ck]; ok {
+		r.mu.Unlock()
+		c.wg.Wait()
+		return c.res
+	}
+	c := &inflight{}
+	c.wg.Add(1)
+	r.calls[ck] = c
+	r.mu.Unlock()
+
+	c.res = fn()
+	r.mu.Lock()
+	// an Invalidate during the call removes it from calls, in which case
+	// the result may be stale and is not cached
+	if r.calls[ck] == c {
+		delete(r.calls, ck)
+		if d := ttl(c.res); c.res.err == nil && d > 0 {
+			if len(r.entries) >= r.p.MaxEntries {
+				r.evict()
+			}
+			r.entries[ck] = &cacheEntry{res: c.res, expires: time.Now().Add(d)}
+		}
+	}
+	r.mu.Unlock()
+	c.wg.Done()
+	return c.res
+}
+
+// evict removes expired entries, and if that does not free a tenth of the
+// cache, the entries closest to expiry. r.mu must be held.
+func (r *CachingResolver) evict() {
+	now := time.Now()
+	for k, e := range r.entries {
+		if !now.Before(e.expires) {
+			delete(r.entries, k)
+		}
+	}
+	target := r.p.MaxEntries - r.p.MaxEntries/10 - 1
+	if len(r.entries) <= target {
+		return
+	}
+	keys := make([]string, 0, len(r.entries))
+	for k := range r.entries {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return r.entries[keys[i]].expires.Before(r.entries[keys[j]].expires)
+	})
+	for _, k := range keys[:len(keys)-target] {
+		delete(r.entries, k)
+	}
+}
+
+// Len returns the number of cached results, including expired ones that
+// have not been swept yet
+func (r *CachingResolver) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.entries)
+}
+
+// ResolveRegistry is a cached BW2Client.ResolveRegistry
+func (r *CachingResolver) ResolveRegistry(key string) (objects.RoutingObject, RegistryValidity, error) {
+	res := r.do("reg\x00"+key, func() *resolution {
+		ro, v, err := r.cl.ResolveRegistry(key)
+		return &resolution{ro: ro, validity: v, err: err}
+	}, func(res *resolution) time.Duration {
+		switch {
+		case res.ro == nil:
+			return r.p.NegativeTTL
+		case res.validity == StateValid:
+			return r.p.ValidTTL
+		case res.validity == StateExpired || res.validity == StateRevoked:
+			return r.p.InvalidTTL
+		}
+		return r.p.UnknownTTL
+	})
+	return res.ro, res.validity, res.err
+}
+
+func (r *CachingResolver) aliasTTL(res *resolution) time.Duration {
+	if res.zero || (res.str == "" && res.data == nil) {
+		return r.p.NegativeTTL
+	}
+	return r.p.AliasTTL
+}
+
+// ResolveLongAlias is a cached BW2Client.ResolveLongAlias
+func (r *CachingResolver) ResolveLongAlias(al string) ([]byte, bool, error) {
+	res := r.do("long\x00"+al, func() *resolution {
+		data, zero, err := r.cl.ResolveLongAlias(al)
+		return &resolution{data: data, zero: zero, err: err}
+	}, r.aliasTTL)
+	return res.data, res.zero, res.err
+}
+
+// ResolveShortAlias is a cached BW2Client.ResolveShortAlias
+func (r *CachingResolver) ResolveShortAlias(al string) ([]byte, bool, error) {
+	res := r.do("short\x00"+al, func() *resolution {
+		data, zero, err := r.cl.ResolveShortAlias(al)
+		return &resolution{data: data, zero: zero, err: err}
+	}, r.aliasTTL)
+	return res.data, res.zero, res.err
+}
+
+// ResolveEmbeddedAlias is a cached BW2Client.ResolveEmbeddedAlias
+func (r *CachingResolver) ResolveEmbeddedAlias(al string) (string, error) {
+	res := r.do("embedded\x00"+al, func() *resolution {
+		str, err := r.cl.ResolveEmbeddedAlias(al)
+		return &resolution{str: str, err: err}
+	}, r.aliasTTL)
+	return res.str, res.err
+}
+
+// Invalidate discards any cached result for the key, whether it was looked
+// up as a registry key or as an alias. Lookups already in progress for the
+// key are not cached.
+func (r *CachingResolver) Invalidate(key string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, kind := range []string{"reg", "long", "short", "embedded"} {
+		delete(r.entries, kind+"\x00"+key)
+		delete(r.calls, kind+"\x00"+key)
+	}
+}
+
+// InvalidateAll discards every cached result
+func (r *CachingResolver) InvalidateAll() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries = make(map[string]*cacheEntry)
+	r.calls = make(map[string]*inflight)
+}
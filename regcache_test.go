@@ -0,0 +1,35 @@
+package bw2bind
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestCachingResolverEvict(t *testing.T) {
+	r := (&BW2Client{}).NewCachingResolver(&ResolverParams{MaxEntries: 20})
+	now := time.Now()
+	for i := 0; i < 20; i++ {
+		r.entries[fmt.Sprint(i)] = &cacheEntry{res: &resolution{}, expires: now.Add(time.Duration(i) * time.Minute)}
+	}
+	r.mu.Lock()
+	r.evict()
+	r.mu.Unlock()
+	// entry 0 has expired, and the next soonest are evicted down to 17
+	if r.Len() != 17 {
+		t.Fatalf("expected 17 entries after eviction, got %d", r.Len())
+	}
+	for _, k := range []string{"0", "1", "2"} {
+		if _, ok := r.entries[k]; ok {
+			t.Errorf("entry %s should have been evicted", k)
+		}
+	}
+	if _, ok := r.entries["19"]; !ok {
+		t.Error("the entry furthest from expiry was evicted")
+	}
+
+	res := r.do("new", func() *resolution { return &resolution{str: "x"} }, func(*resolution) time.Duration { return time.Hour })
+	if res.str != "x" || r.Len() != 18 {
+		t.Fatalf("expected the new result to be cached, have %d entries", r.Len())
+	}
+}
@@ -0,0 +1,291 @@
+package bw2bind
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/immesys/bw2/crypto"
+	"github.com/immesys/bw2/objects"
+)
+
+const (
+	// DefaultWarnBefore is how long before expiry a tracked DOT is warned about
+	DefaultWarnBefore = 7 * 24 * time.Hour
+	// DefaultRenewalCheckInterval is how often tracked DOTs are checked
+	DefaultRenewalCheckInterval = time.Hour
+	// DefaultRenewFor is the lifetime of a replacement DOT when the
+	// original's lifetime cannot be worked out
+	DefaultRenewFor = 30 * 24 * time.Hour
+)
+
+// TrackedDOT is an access DOT watched by a RenewalManager
+type TrackedDOT struct {
+	Hash        string
+	To          string
+	URI         string
+	Permissions string
+	TTL         uint8
+	Contact     string
+	Comment     string
+	Revokers    []string
+	Created     *time.Time
+	Expiry      *time.Time
+	// guarded by RenewalManager.mu
+	warned bool
+}
+
+// Lifetime returns the time between creation and expiry, or zero if the
+// DOT does not have both
+func (t *TrackedDOT) Lifetime() time.Duration {
+	if t.Created == nil || t.Expiry == nil {
+		return 0
+	}
+	return t.Expiry.Sub(*t.Created)
+}
+
+// RenewalParams configures a RenewalManager. Zero durations take the
+// defaults above.
+type RenewalParams struct {
+	// Warn about DOTs expiring within this duration
+	WarnBefore time.Duration
+	// Replace DOTs expiring within this duration. If zero, DOTs are only
+	// warned about and never renewed
+	RenewBefore time.Duration
+	// The lifetime of replacement DOTs. If zero, the lifetime of the
+	// original DOT is used
+	RenewFor time.Duration
+	// The account that pays for publishing replacement DOTs
+	Account int
+	// How often to check the tracked DOTs
+	CheckInterval time.Duration
+	// Called when a DOT is first found to be within WarnBefore of expiry
+	OnWarn func(d *TrackedDOT)
+	// Called after a DOT has been replaced and is no longer tracked
+	OnRenew func(prev, next *TrackedDOT)
+	// Called when a DOT could not be renewed. It is retried on the next check
+	OnError func(d *TrackedDOT, err error)
+}
+
+// RenewalManager watches DOTs granted by the current entity and warns
+// before they expire, and optionally replaces them with new DOTs with the
+// same receiver, URI, permissions and TTL.
+type RenewalManager struct {
+	cl    *BW2Client
+	p     RenewalParams
+	mu    sync.Mutex
+	dots  map[string]*TrackedDOT
+	stop  chan struct{}
+	once  sync.Once
+	check sync.Mutex
+}
+
+// NewRenewalManager starts a renewal manager. Replacement DOTs are granted
+// by the entity set with SetEntity, so only track DOTs granted by it.
+func (cl *BW2Client) NewRenewalManager(p *RenewalParams) *RenewalManager {
+	rm := &RenewalManager{
+		cl:   cl,
+		p:    *p,
+		dots: make(map[string]*TrackedDOT),
+		stop: make(chan struct{}),
+	}
+	if rm.p.WarnBefore == 0 {
+		rm.p.WarnBefore = DefaultWarnBefore
+	}
+	if rm.p.CheckInterval == 0 {
+		rm.p.CheckInterval = DefaultRenewalCheckInterval
+	}
+	go rm.loop()
+	return rm
+}
+
+func (rm *RenewalManager) loop() {
+	t := time.NewTicker(rm.p.CheckInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-rm.stop:
+			return
+		case <-t.C:
+			rm.Check()
+		}
+	}
+}
+
+// Stop stops checking the tracked DOTs
+func (rm *RenewalManager) Stop() {
+	rm.once.Do(func() { close(rm.stop) })
+}
+
+// Track looks up the DOT with the given hash in the registry and starts
+// tracking it
+func (rm *RenewalManager) Track(hash string) (*TrackedDOT, error) {
+	ro, validity, err := rm.cl.ResolveRegistry(hash)
+	if err != nil {
+		return nil, err
+	}
+	if ro == nil {
+		return nil, fmt.Errorf("DOT %s not found", hash)
+	}
+	d, ok := ro.(*objects.DOT)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a DOT", hash)
+	}
+	if validity == StateRevoked {
+		return nil, fmt.Errorf("DOT %s is revoked", hash)
+	}
+	return rm.TrackDOT(d)
+}
+
+// TrackBlob starts tracking a DOT given its binary representation, with or
+// without the file type header
+func (rm *RenewalManager) TrackBlob(blob []byte) (*TrackedDOT, error) {
+	ro, err := parseWithHeader(blob, []int{objects.ROAccessDOT}, objects.NewDOT, func(ro objects.RoutingObject) bool {
+		return ro.(*objects.DOT).SigValid()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rm.TrackDOT(ro.(*objects.DOT))
+}
+
+// TrackDOT starts tracking the given DOT
+func (rm *RenewalManager) TrackDOT(d *objects.DOT) (*TrackedDOT, error) {
+	if !d.IsAccess() {
+		return nil, errors.New("only access DOTs can be renewed")
+	}
+	td := &TrackedDOT{
+		Hash:        crypto.FmtHash(d.GetHash()),
+		To:          crypto.FmtKey(d.GetReceiverVK()),
+		URI:         crypto.FmtKey(d.GetAccessURIMVK()) + "/" + d.GetAccessURISuffix(),
+		Permissions: d.GetPermString(),
+		TTL:         uint8(d.GetTTL()),
+		Contact:     d.GetContact(),
+		Comment:     d.GetComment(),
+		Revokers:    fmtKeys(d.GetRevokers()),
+		Created:     d.GetCreated(),
+		Expiry:      d.GetExpiry(),
+	}
+	rm.mu.Lock()
+	rm.dots[td.Hash] = td
+	rm.mu.Unlock()
+	return td, nil
+}
+
+// Untrack stops tracking the DOT with the given hash
+func (rm *RenewalManager) Untrack(hash string) {
+	rm.mu.Lock()
+	delete(rm.dots, hash)
+	rm.mu.Unlock()
+}
+
+// Tracked returns the tracked DOTs, soonest expiry first. DOTs that do not
+// expire come last.
+func (rm *RenewalManager) Tracked() []*TrackedDOT {
+	rm.mu.Lock()
+	rv := make([]*TrackedDOT, 0, len(rm.dots))
+	for _, td := range rm.dots {
+		rv = append(rv, td)
+	}
+	rm.mu.Unlock()
+	sort.Slice(rv, func(i, j int) bool {
+		if rv[i].Expiry == nil || rv[j].Expiry == nil {
+			return rv[j].Expiry == nil && rv[i].Expiry != nil
+		}
+		return rv[i].Expiry.Before(*rv[j].Expiry)
+	})
+	return rv
+}
+
+// Check warns about and renews the tracked DOTs now rather than waiting
+// for the next check interval
+func (rm *RenewalManager) Check() {
+	rm.check.Lock()
+	defer rm.check.Unlock()
+	now := time.Now()
+	for _, td := range rm.Tracked() {
+		warn, renew := rm.p.due(td, now)
+		rm.mu.Lock()
+		warn = warn && !td.warned
+		if warn {
+			td.warned = true
+		}
+		rm.mu.Unlock()
+		if warn {
+			log.Warnf("DOT %s to %s on %s expires in %s", td.Hash, td.To, td.URI, td.Expiry.Sub(now))
+			if rm.p.OnWarn != nil {
+				rm.p.OnWarn(td)
+			}
+		}
+		if renew {
+			if _, err := rm.Renew(td.Hash); err != nil {
+				log.Warnf("could not renew DOT %s: %v", td.Hash, err)
+				if rm.p.OnError != nil {
+					rm.p.OnError(td, err)
+				}
+			}
+		}
+	}
+}
+
+// due returns whether the DOT is close enough to expiry at now to be
+// warned about and to be renewed
+func (p *RenewalParams) due(td *TrackedDOT, now time.Time) (warn, renew bool) {
+	if td.Expiry == nil {
+		return false, false
+	}
+	left := td.Expiry.Sub(now)
+	return left <= p.WarnBefore, p.RenewBefore != 0 && left <= p.RenewBefore
+}
+
+// lifetime returns the lifetime of the replacement for the DOT
+func (p *RenewalParams) lifetime(td *TrackedDOT) time.Duration {
+	if p.RenewFor != 0 {
+		return p.RenewFor
+	}
+	if l := td.Lifetime(); l > 0 {
+		return l
+	}
+	return DefaultRenewFor
+}
+
+// Renew replaces the tracked DOT with a new one with the same receiver,
+// URI, permissions and TTL, publishes it and tracks it instead
+func (rm *RenewalManager) Renew(hash string) (*TrackedDOT, error) {
+	rm.mu.Lock()
+	old, ok := rm.dots[hash]
+	rm.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("DOT %s is not tracked", hash)
+	}
+	lifetime := rm.p.lifetime(old)
+	_, blob, err := rm.cl.CreateDOT(&CreateDOTParams{
+		To:                old.To,
+		TTL:               old.TTL,
+		ExpiryDelta:       &lifetime,
+		Contact:           old.Contact,
+		Comment:           old.Comment,
+		Revokers:          old.Revokers,
+		URI:               old.URI,
+		AccessPermissions: old.Permissions,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := rm.cl.PublishDOTWithAcc(blob, rm.p.Account); err != nil {
+		return nil, err
+	}
+	td, err := rm.TrackBlob(blob)
+	if err != nil {
+		return nil, err
+	}
+	rm.Untrack(hash)
+	log.Infof("renewed DOT %s as %s", old.Hash, td.Hash)
+	if rm.p.OnRenew != nil {
+		rm.p.OnRenew(old, td)
+	}
+	return td, nil
+}
@@ -0,0 +1,105 @@
+package bw2bind
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRenewalDue(t *testing.T) {
+	now := time.Unix(1000000, 0)
+	at := func(d time.Duration) *time.Time {
+		t := now.Add(d)
+		return &t
+	}
+	tests := []struct {
+		name        string
+		p           RenewalParams
+		expiry      *time.Time
+		warn, renew bool
+	}{
+		{"never expires", RenewalParams{WarnBefore: time.Hour, RenewBefore: time.Hour}, nil, false, false},
+		{"far off", RenewalParams{WarnBefore: time.Hour, RenewBefore: time.Minute}, at(2 * time.Hour), false, false},
+		{"within warning", RenewalParams{WarnBefore: time.Hour, RenewBefore: time.Minute}, at(30 * time.Minute), true, false},
+		{"on the warning edge", RenewalParams{WarnBefore: time.Hour}, at(time.Hour), true, false},
+		{"within renewal", RenewalParams{WarnBefore: time.Hour, RenewBefore: time.Minute}, at(30 * time.Second), true, true},
+		{"renewal without warning", RenewalParams{WarnBefore: time.Minute, RenewBefore: time.Hour}, at(30 * time.Minute), false, true},
+		{"renewal disabled", RenewalParams{WarnBefore: time.Hour}, at(time.Second), true, false},
+		{"already expired", RenewalParams{WarnBefore: time.Hour, RenewBefore: time.Minute}, at(-time.Hour), true, true},
+	}
+	for _, tt := range tests {
+		warn, renew := tt.p.due(&TrackedDOT{Expiry: tt.expiry}, now)
+		if warn != tt.warn || renew != tt.renew {
+			t.Errorf("%s: warn %v renew %v, expected %v %v", tt.name, warn, renew, tt.warn, tt.renew)
+		}
+	}
+}
+
+func TestRenewalLifetime(t *testing.T) {
+	created := time.Unix(1000000, 0)
+	expiry := created.Add(10 * 24 * time.Hour)
+	tests := []struct {
+		name     string
+		renewFor time.Duration
+		td       TrackedDOT
+		want     time.Duration
+	}{
+		{"configured", time.Hour, TrackedDOT{Created: &created, Expiry: &expiry}, time.Hour},
+		{"original lifetime", 0, TrackedDOT{Created: &created, Expiry: &expiry}, 10 * 24 * time.Hour},
+		{"no creation date", 0, TrackedDOT{Expiry: &expiry}, DefaultRenewFor},
+		{"expires before creation", 0, TrackedDOT{Created: &expiry, Expiry: &created}, DefaultRenewFor},
+	}
+	for _, tt := range tests {
+		p := RenewalParams{RenewFor: tt.renewFor}
+		if got := p.lifetime(&tt.td); got != tt.want {
+			t.Errorf("%s: lifetime %s, expected %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRenewalCheckWarnsOnce(t *testing.T) {
+	soon, later := time.Now().Add(time.Minute), time.Now().Add(48*time.Hour)
+	warned := map[string]int{}
+	rm := &RenewalManager{
+		p: RenewalParams{WarnBefore: time.Hour, OnWarn: func(d *TrackedDOT) {
+			warned[d.Hash]++
+		}},
+		dots: map[string]*TrackedDOT{
+			"soon":  {Hash: "soon", Expiry: &soon},
+			"later": {Hash: "later", Expiry: &later},
+			"never": {Hash: "never"},
+		},
+	}
+	rm.Check()
+	rm.Check()
+	if warned["soon"] != 1 || len(warned) != 1 {
+		t.Errorf("warnings given: %v", warned)
+	}
+	if ts := rm.Tracked(); ts[0].Hash != "soon" || ts[1].Hash != "later" || ts[2].Hash != "never" {
+		t.Errorf("Tracked is not ordered by expiry: %v %v %v", ts[0].Hash, ts[1].Hash, ts[2].Hash)
+	}
+}
+
+func TestRenewalCheckReportsErrors(t *testing.T) {
+	_, cl := newFakeRouter(t, func(fr *frame, send func(*frame)) {
+		send(failed(fr, "no entity set"))
+	})
+	soon := time.Now().Add(time.Minute)
+	var failedDOT *TrackedDOT
+	var renewErr error
+	rm := &RenewalManager{
+		cl: cl,
+		p: RenewalParams{WarnBefore: time.Hour, RenewBefore: time.Hour, OnError: func(d *TrackedDOT, err error) {
+			failedDOT, renewErr = d, err
+		}, OnRenew: func(prev, next *TrackedDOT) {
+			t.Error("renewed without a router")
+		}},
+		dots: map[string]*TrackedDOT{"soon": {Hash: "soon", Expiry: &soon}},
+	}
+	within(t, 5*time.Second, "Check", rm.Check)
+	if failedDOT == nil || failedDOT.Hash != "soon" || renewErr == nil {
+		t.Fatalf("renewal error reported as %v %v", failedDOT, renewErr)
+	}
+	if len(rm.Tracked()) != 1 {
+		t.Error("a DOT that could not be renewed is no longer tracked")
+	}
+}
@@ -0,0 +1,215 @@
+package bw2bind
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+)
+
+// DefaultRevocationInterval is how often a RevocationWatcher resolves the
+// keys it watches
+const DefaultRevocationInterval = 5 * time.Minute
+
+// RevocationEvent describes a change in the validity of a watched VK or DOT
+type RevocationEvent struct {
+	// The VK or DOT hash
+	Key      string
+	Previous RegistryValidity
+	Current  RegistryValidity
+}
+
+// RevocationWatcherParams configures a RevocationWatcher
+type RevocationWatcherParams struct {
+	// How often to resolve the watched keys, DefaultRevocationInterval if zero
+	Interval time.Duration
+	// Called for every event, before it is written to the Events channel
+	OnChange func(ev *RevocationEvent)
+	// Called when a watched key becomes revoked or expired, e.g. to switch
+	// identity or stop publishing
+	OnInvalid func(ev *RevocationEvent)
+}
+
+// RevocationWatcher periodically resolves a set of VKs and DOT hashes in
+// the registry and emits an event when one changes between valid, expired
+// and revoked. The first resolution of a key only emits an event if the
+// key is not valid.
+type RevocationWatcher struct {
+	cl       *BW2Client
+	p        RevocationWatcherParams
+	lookup   func(key string) (RegistryValidity, error)
+	mu       sync.Mutex
+	state    map[string]RegistryValidity
+	events   chan *RevocationEvent
+	stop     chan struct{}
+	stopOnce sync.Once
+	check    sync.Mutex
+	closed   bool
+}
+
+// WatchRevocations starts a watcher for the given VKs and DOT hashes. More
+// keys can be added with Watch and WatchChain.
+func (cl *BW2Client) WatchRevocations(p *RevocationWatcherParams, keys ...string) *RevocationWatcher {
+	rv := &RevocationWatcher{
+		cl:     cl,
+		p:      *p,
+		state:  make(map[string]RegistryValidity),
+		events: make(chan *RevocationEvent, 10),
+		stop:   make(chan struct{}),
+	}
+	if rv.p.Interval == 0 {
+		rv.p.Interval = DefaultRevocationInterval
+	}
+	rv.lookup = func(key string) (RegistryValidity, error) {
+		_, v, err := cl.ResolveRegistry(key)
+		return v, err
+	}
+	rv.Watch(keys...)
+	go rv.loop()
+	return rv
+}
+
+// Watch adds VKs or DOT hashes to the watched set. They are resolved on
+// the next check.
+func (rw *RevocationWatcher) Watch(keys ...string) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	for _, k := range keys {
+		if _, ok := rw.state[k]; !ok {
+			rw.state[k] = StateUnknown
+		}
+	}
+}
+
+// WatchChain watches every DOT in a chain returned by BuildChain, and if
+// the chain is elaborated, every VK in it
+func (rw *RevocationWatcher) WatchChain(sc *SimpleChain) error {
+	ci, err := ParseChain(sc.Content)
+	if err != nil {
+		return err
+	}
+	rw.Watch(ci.DOTHashes...)
+	for _, d := range ci.DOTs {
+		rw.Watch(d.From, d.To)
+	}
+	return nil
+}
+
+// Unwatch removes keys from the watched set
+func (rw *RevocationWatcher) Unwatch(keys ...string) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	for _, k := range keys {
+		delete(rw.state, k)
+	}
+}
+
+// State returns the last known validity of a watched key
+func (rw *RevocationWatcher) State(key string) RegistryValidity {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	return rw.state[key]
+}
+
+// Events returns the channel that revocation events are written to. It is
+// closed when the watcher is stopped.
+func (rw *RevocationWatcher) Events() <-chan *RevocationEvent {
+	return rw.events
+}
+
+// Stop stops the watcher and closes the event channel
+func (rw *RevocationWatcher) Stop() {
+	rw.stopOnce.Do(func() { close(rw.stop) })
+}
+
+func (rw *RevocationWatcher) loop() {
+	defer func() {
+		rw.check.Lock()
+		rw.closed = true
+		close(rw.events)
+		rw.check.Unlock()
+	}()
+	tick := time.NewTicker(rw.p.Interval)
+	defer tick.Stop()
+	for {
+		if !rw.Check() {
+			return
+		}
+		select {
+		case <-rw.stop:
+			return
+		case <-tick.C:
+		}
+	}
+}
+
+// Check resolves every watched key now and emits events for those that
+// changed. It returns false if the watcher was stopped. The callbacks are
+// called without any lock held, so they may call back into the watcher.
+func (rw *RevocationWatcher) Check() bool {
+	evs, ok := rw.resolve()
+	if !ok {
+		return false
+	}
+	for _, ev := range evs {
+		if rw.p.OnChange != nil {
+			rw.p.OnChange(ev)
+		}
+		if ev.Current != StateValid && rw.p.OnInvalid != nil {
+			rw.p.OnInvalid(ev)
+		}
+	}
+	rw.check.Lock()
+	defer rw.check.Unlock()
+	if rw.closed {
+		return false
+	}
+	for _, ev := range evs {
+		select {
+		case rw.events <- ev:
+		case <-rw.stop:
+			return false
+		}
+	}
+	return true
+}
+
+// resolve resolves every watched key, updates their state and returns the
+// changes. It returns false if the watcher was stopped.
+func (rw *RevocationWatcher) resolve() ([]*RevocationEvent, bool) {
+	rw.check.Lock()
+	defer rw.check.Unlock()
+	if rw.closed {
+		return nil, false
+	}
+	rw.mu.Lock()
+	keys := make([]string, 0, len(rw.state))
+	for k := range rw.state {
+		keys = append(keys, k)
+	}
+	rw.mu.Unlock()
+	sort.Strings(keys)
+	var rv []*RevocationEvent
+	for _, k := range keys {
+		cur, err := rw.lookup(k)
+		if err != nil {
+			log.Warnf("could not resolve %s: %v", k, err)
+			continue
+		}
+		if cur == StateUnknown {
+			continue
+		}
+		rw.mu.Lock()
+		prev, ok := rw.state[k]
+		if ok {
+			rw.state[k] = cur
+		}
+		rw.mu.Unlock()
+		if !ok || prev == cur || (prev == StateUnknown && cur == StateValid) {
+			continue
+		}
+		rv = append(rv, &RevocationEvent{Key: k, Previous: prev, Current: cur})
+	}
+	return rv, true
+}
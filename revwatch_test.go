@@ -0,0 +1,67 @@
+package bw2bind
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestRevocationWatcherCheck(t *testing.T) {
+	registry := map[string]RegistryValidity{}
+	rw := &RevocationWatcher{
+		state:  make(map[string]RegistryValidity),
+		events: make(chan *RevocationEvent, 10),
+		stop:   make(chan struct{}),
+		lookup: func(key string) (RegistryValidity, error) {
+			if key == "broken" {
+				return StateError, errors.New("router unreachable")
+			}
+			return registry[key], nil
+		},
+	}
+	var invalid []string
+	rw.p.OnInvalid = func(ev *RevocationEvent) {
+		invalid = append(invalid, ev.Key)
+	}
+	step := func(name string, want ...string) {
+		invalid = nil
+		if !rw.Check() {
+			t.Fatalf("%s: Check reported the watcher stopped", name)
+		}
+		var got []string
+		for len(rw.events) > 0 {
+			ev := <-rw.events
+			got = append(got, fmt.Sprintf("%s:%d>%d", ev.Key, ev.Previous, ev.Current))
+		}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("%s: events %v, expected %v", name, got, want)
+		}
+	}
+	rw.Watch("dot", "vk", "gone", "broken")
+	registry["dot"] = StateValid
+	registry["vk"] = StateExpired
+	// a valid key's first resolution is not an event, an invalid one is,
+	// and keys that are unknown or fail to resolve keep their state
+	step("first check", fmt.Sprintf("vk:%d>%d", StateUnknown, StateExpired))
+	if fmt.Sprint(invalid) != "[vk]" {
+		t.Errorf("OnInvalid called for %v", invalid)
+	}
+	if rw.State("dot") != StateValid || rw.State("gone") != StateUnknown || rw.State("broken") != StateUnknown {
+		t.Errorf("states are dot %d, gone %d, broken %d", rw.State("dot"), rw.State("gone"), rw.State("broken"))
+	}
+	step("unchanged")
+	registry["dot"] = StateRevoked
+	registry["gone"] = StateValid
+	step("revoked", fmt.Sprintf("dot:%d>%d", StateValid, StateRevoked))
+	rw.Unwatch("dot")
+	registry["dot"] = StateValid
+	step("unwatched")
+	if rw.State("dot") != StateUnknown {
+		t.Errorf("unwatched key has state %d", rw.State("dot"))
+	}
+	rw.Stop()
+	rw.closed = true
+	if rw.Check() {
+		t.Error("Check after the watcher closed did not report it stopped")
+	}
+}
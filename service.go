@@ -19,12 +19,12 @@ func handleErr(err error) {
 }
 
 type Service struct {
-	cl           *BW2Client
-	name         string
-	baseuri      string
-	ifaces       []*Interface
-	mu           *sync.Mutex
-	errorHandler func(error)
+	cl      *BW2Client
+	name    string
+	baseuri string
+	ifaces  []*Interface
+	mu      *sync.Mutex
+	life    *lifecycle
 }
 
 type Interface struct {
@@ -33,48 +33,46 @@ type Interface struct {
 	name   string
 	auto   bool
 	last   time.Time
+	schema InterfaceSchema
 }
 
 func (cl *BW2Client) RegisterService(baseuri string, name string) *Service {
 	baseuri = strings.TrimSuffix(baseuri, "/")
-	rv := &Service{cl: cl, baseuri: baseuri, name: name, mu: &sync.Mutex{}}
+	rv := &Service{cl: cl, baseuri: baseuri, name: name, mu: &sync.Mutex{}, life: newLifecycle(cl)}
 	go rv.registerLoop()
 	return rv
 }
 
 func (cl *BW2Client) RegisterServiceNoHb(baseuri string, name string) *Service {
 	baseuri = strings.TrimSuffix(baseuri, "/")
-	rv := &Service{cl: cl, baseuri: baseuri, name: name, mu: &sync.Mutex{}}
+	rv := &Service{cl: cl, baseuri: baseuri, name: name, mu: &sync.Mutex{}, life: newLifecycle(cl)}
 	return rv
 }
 
 func (s *Service) registerLoop() {
 	//Initial delay is lower
-	time.Sleep(1 * time.Second)
+	delay := 1 * time.Second
 	for {
+		select {
+		case <-s.life.done:
+			return
+		case <-time.After(delay):
+		}
+		delay = RegistrationInterval * time.Second
 		if err := s.cl.SetMetadata(s.baseuri+"/"+s.name, "lastalive", time.Now().String()); err != nil {
-			if s.errorHandler != nil {
-				s.errorHandler(err)
-			} else {
-				handleErr(err)
-			}
+			s.life.report(err, handleErr)
 		} else {
 			s.mu.Lock()
 			for _, i := range s.ifaces {
 				if i.auto {
 					if err := i.updateRegistration(); err != nil {
-						if s.errorHandler != nil {
-							s.errorHandler(err)
-						} else {
-							handleErr(err)
-						}
+						s.life.report(err, handleErr)
 						break
 					}
 				}
 			}
 			s.mu.Unlock()
 		}
-		time.Sleep(RegistrationInterval * time.Second)
 	}
 }
 
@@ -119,7 +117,7 @@ func (s *Service) SetMetadata(key, val string) error {
 }
 
 func (s *Service) SetErrorHandler(f func(error)) {
-	s.errorHandler = f
+	s.life.setErrorHandler(f)
 }
 
 func (ifc *Interface) FullURI() string {
@@ -154,32 +152,18 @@ func (ifc *Interface) PublishSignal(signal string, poz ...PayloadObject) error {
 	})
 }
 func (ifc *Interface) SubscribeSlot(slot string, cb func(*SimpleMessage)) error {
-	rc, err := ifc.svc.cl.Subscribe(&SubscribeParams{
-		URI:       ifc.SlotURI(slot),
-		AutoChain: true,
-	})
-	if err != nil {
-		return err
-	}
-	go func() {
-		for sm := range rc {
-			cb(sm)
-		}
-	}()
-	return nil
+	_, err := ifc.SubscribeSlotH(slot, cb)
+	return err
 }
 func (ifc *Interface) SubscribeSlotH(slot string, cb func(*SimpleMessage)) (string, error) {
-	rc, handle, err := ifc.svc.cl.SubscribeH(&SubscribeParams{
+	return ifc.SubscribeSlotWith(slot, nil, cb)
+}
+
+// SubscribeSlotWith is like SubscribeSlotH but lets you choose how messages
+// are dispatched to the callback, e.g. to handle slow actuations concurrently
+func (ifc *Interface) SubscribeSlotWith(slot string, opts *DispatchOptions, cb func(*SimpleMessage)) (string, error) {
+	return ifc.svc.life.subscribe(&SubscribeParams{
 		URI:       ifc.SlotURI(slot),
 		AutoChain: true,
-	})
-	if err != nil {
-		return "", err
-	}
-	go func() {
-		for sm := range rc {
-			cb(sm)
-		}
-	}()
-	return handle, nil
+	}, opts, cb)
 }
@@ -1,63 +1,167 @@
 package bw2bind
 
 import (
+	"sort"
 	"strings"
 	"sync"
+	"time"
 )
 
-type ServiceClient Service
-type InterfaceClient Interface
+// ServiceClient is the client side view of a service registered with
+// RegisterService. It tracks the interfaces that have been added to it or
+// discovered on the router, and owns their signal subscriptions.
+type ServiceClient struct {
+	cl      *BW2Client
+	name    string
+	baseuri string
+	mu      sync.Mutex
+	ifaces  []*InterfaceClient
+	life    *lifecycle
+}
+
+// InterfaceClient is the client side view of an interface on a service
+type InterfaceClient struct {
+	sc     *ServiceClient
+	prefix string
+	name   string
+}
 
 func (cl *BW2Client) NewServiceClient(baseuri string, name string) *ServiceClient {
 	baseuri = strings.TrimSuffix(baseuri, "/")
-	return &ServiceClient{cl: cl, baseuri: baseuri, name: name, mu: &sync.Mutex{}}
+	return &ServiceClient{cl: cl, baseuri: baseuri, name: name, life: newLifecycle(cl)}
 }
 
+func (sc *ServiceClient) FullURI() string {
+	return sc.baseuri + "/" + sc.name
+}
+
+// AddInterface returns the client for the given interface on this service.
+// Adding the same interface twice returns the same client.
 func (sc *ServiceClient) AddInterface(prefix string, name string) *InterfaceClient {
-	svc := Service(*sc)
-	ifc := Interface{
-		svc:    &svc,
-		prefix: prefix,
-		name:   name,
-		auto:   false,
+	prefix = strings.TrimSuffix(prefix, "/")
+	prefix = strings.TrimPrefix(prefix, "/")
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	for _, ifc := range sc.ifaces {
+		if ifc.prefix == prefix && ifc.name == name {
+			return ifc
+		}
+	}
+	rv := &InterfaceClient{sc: sc, prefix: prefix, name: name}
+	sc.ifaces = append(sc.ifaces, rv)
+	return rv
+}
+
+// Interfaces queries the router for every interface that has published
+// a heartbeat below this service, and returns them along with any
+// interfaces added locally with AddInterface
+func (sc *ServiceClient) Interfaces() ([]*InterfaceClient, error) {
+	beats, err := sc.cl.queryHeartbeats(sc.FullURI())
+	if err != nil {
+		return nil, err
+	}
+	for uri := range beats {
+		if !strings.HasPrefix(uri, sc.FullURI()+"/") {
+			continue
+		}
+		rel := strings.TrimPrefix(uri, sc.FullURI()+"/")
+		idx := strings.LastIndex(rel, "/")
+		if idx < 0 {
+			sc.AddInterface("", rel)
+		} else {
+			sc.AddInterface(rel[:idx], rel[idx+1:])
+		}
 	}
 	sc.mu.Lock()
-	sc.ifaces = append(sc.ifaces, &ifc)
+	rv := make([]*InterfaceClient, len(sc.ifaces))
+	copy(rv, sc.ifaces)
 	sc.mu.Unlock()
-
-	rv := InterfaceClient(ifc)
-	return &rv
+	sort.Slice(rv, func(i, j int) bool {
+		return rv[i].FullURI() < rv[j].FullURI()
+	})
+	return rv, nil
 }
 
 func (sc *ServiceClient) GetMetadata() (map[string]*MetadataTuple, error) {
-	svc := Service(*sc)
-	md, _, err := sc.cl.GetMetadata(svc.FullURI())
+	md, _, err := sc.cl.GetMetadata(sc.FullURI())
 	return md, err
 }
 
 func (sc *ServiceClient) GetMetadataKey(key string) (*MetadataTuple, error) {
-	svc := Service(*sc)
-	md, _, err := sc.cl.GetMetadataKey(svc.FullURI(), key)
+	md, _, err := sc.cl.GetMetadataKey(sc.FullURI(), key)
 	return md, err
 }
 
+// LastAlive returns the time of the last heartbeat of the service, or the
+// zero time if it has never published one
+func (sc *ServiceClient) LastAlive() (time.Time, error) {
+	return sc.cl.lastAlive(sc.FullURI())
+}
+
+// IsAlive returns true if the service has published a heartbeat within
+// DefaultStaleAfter
+func (sc *ServiceClient) IsAlive() (bool, error) {
+	t, err := sc.LastAlive()
+	return err == nil && time.Now().Sub(t) <= DefaultStaleAfter, err
+}
+
+func (cl *BW2Client) lastAlive(uri string) (time.Time, error) {
+	sm, err := cl.QueryOne(&QueryParams{
+		URI:       uri + lastAliveSuffix,
+		AutoChain: true,
+	})
+	if err != nil || sm == nil {
+		return time.Time{}, err
+	}
+	_, t, _ := parseHeartbeat(sm)
+	return t, nil
+}
+
+// Service returns the client for the service this interface belongs to
+func (ifclient *InterfaceClient) Service() *ServiceClient {
+	return ifclient.sc
+}
+
+func (ifclient *InterfaceClient) FullURI() string {
+	if ifclient.prefix == "" {
+		return ifclient.sc.FullURI() + "/" + ifclient.name
+	}
+	return ifclient.sc.FullURI() + "/" + ifclient.prefix + "/" + ifclient.name
+}
+
 func (ifclient *InterfaceClient) SignalURI(signal string) string {
-	ifc := Interface(*ifclient)
-	return ifc.SignalURI(signal)
+	return ifclient.FullURI() + "/signal/" + signal
 }
 
 func (ifclient *InterfaceClient) SlotURI(slot string) string {
-	ifc := Interface(*ifclient)
-	return ifc.SlotURI(slot)
+	return ifclient.FullURI() + "/slot/" + slot
 }
 
-func (ifclient *InterfaceClient) FullURI() string {
-	ifc := Interface(*ifclient)
-	return ifc.FullURI()
+func (ifclient *InterfaceClient) GetMetadata() (map[string]*MetadataTuple, error) {
+	md, _, err := ifclient.sc.cl.GetMetadata(ifclient.FullURI())
+	return md, err
+}
+
+func (ifclient *InterfaceClient) GetMetadataKey(key string) (*MetadataTuple, error) {
+	md, _, err := ifclient.sc.cl.GetMetadataKey(ifclient.FullURI(), key)
+	return md, err
+}
+
+// LastAlive returns the time of the last heartbeat of the interface, or
+// the zero time if it has never published one
+func (ifclient *InterfaceClient) LastAlive() (time.Time, error) {
+	return ifclient.sc.cl.lastAlive(ifclient.FullURI())
+}
+
+// IsAlive returns true if the interface has published a heartbeat within
+// DefaultStaleAfter
+func (ifclient *InterfaceClient) IsAlive() (bool, error) {
+	t, err := ifclient.LastAlive()
+	return err == nil && time.Now().Sub(t) <= DefaultStaleAfter, err
 }
 
 func (ifclient *InterfaceClient) PublishSlot(slot string, poz ...PayloadObject) error {
-	return ifclient.svc.cl.Publish(&PublishParams{
+	return ifclient.sc.cl.Publish(&PublishParams{
 		URI:            ifclient.SlotURI(slot),
 		AutoChain:      true,
 		PayloadObjects: poz,
@@ -65,35 +169,19 @@ func (ifclient *InterfaceClient) PublishSlot(slot string, poz ...PayloadObject)
 }
 
 func (ifclient *InterfaceClient) SubscribeSignal(signal string, cb func(*SimpleMessage)) error {
-	subChan, err := ifclient.svc.cl.Subscribe(&SubscribeParams{
-		URI:       ifclient.SignalURI(signal),
-		AutoChain: true,
-	})
-	if err != nil {
-		return err
-	}
-
-	go func() {
-		for sm := range subChan {
-			cb(sm)
-		}
-	}()
-	return nil
+	_, err := ifclient.SubscribeSignalH(signal, cb)
+	return err
 }
 
 func (ifclient *InterfaceClient) SubscribeSignalH(signal string, cb func(*SimpleMessage)) (string, error) {
-	subChan, handle, err := ifclient.svc.cl.SubscribeH(&SubscribeParams{
+	return ifclient.SubscribeSignalWith(signal, nil, cb)
+}
+
+// SubscribeSignalWith is like SubscribeSignalH but lets you choose how
+// messages are dispatched to the callback
+func (ifclient *InterfaceClient) SubscribeSignalWith(signal string, opts *DispatchOptions, cb func(*SimpleMessage)) (string, error) {
+	return ifclient.sc.life.subscribe(&SubscribeParams{
 		URI:       ifclient.SignalURI(signal),
 		AutoChain: true,
-	})
-	if err != nil {
-		return "", err
-	}
-
-	go func() {
-		for sm := range subChan {
-			cb(sm)
-		}
-	}()
-	return handle, nil
+	}, opts, cb)
 }
@@ -0,0 +1,112 @@
+package bw2bind
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAddInterface(t *testing.T) {
+	sc := (&BW2Client{}).NewServiceClient("ns/base/", "s.a")
+	ifc := sc.AddInterface("/dev/", "i.x")
+	if ifc.FullURI() != "ns/base/s.a/dev/i.x" {
+		t.Errorf("interface URI is %s", ifc.FullURI())
+	}
+	if ifc.SignalURI("on") != "ns/base/s.a/dev/i.x/signal/on" || ifc.SlotURI("set") != "ns/base/s.a/dev/i.x/slot/set" {
+		t.Errorf("signal and slot URIs are %s and %s", ifc.SignalURI("on"), ifc.SlotURI("set"))
+	}
+	if top := sc.AddInterface("", "i.top"); top.FullURI() != "ns/base/s.a/i.top" {
+		t.Errorf("interface without a prefix has URI %s", top.FullURI())
+	}
+	if again := sc.AddInterface("dev", "i.x"); again != ifc {
+		t.Error("adding the same interface again returned a new client")
+	}
+	if other := sc.AddInterface("dev", "i.y"); other == ifc || other.Service() != sc {
+		t.Error("adding another interface returned the wrong client")
+	}
+}
+
+// heartbeatRouter answers queries for heartbeats below a URI, or of a
+// single URI, from beats
+func heartbeatRouter(t *testing.T, beats map[string]time.Time) (*fakeRouter, *BW2Client) {
+	return newFakeRouter(t, func(req *frame, send func(*frame)) {
+		if req.Cmd != cmdQuery {
+			send(okay(req))
+			return
+		}
+		send(okay(req))
+		uri, _ := req.GetFirstHeader("uri")
+		uri = strings.TrimSuffix(uri, lastAliveSuffix)
+		below := strings.HasSuffix(uri, "/*")
+		uri = strings.TrimSuffix(uri, "/*")
+		for b, ts := range beats {
+			if b == uri || below && strings.HasPrefix(b, uri+"/") {
+				res := result(req, "from", testVK(1), "uri", b+lastAliveSuffix)
+				res.AddPayloadObject(CreateMetadataPayloadObject(&MetadataTuple{Value: "1", Timestamp: ts.UnixNano()}))
+				send(res)
+			}
+		}
+		send(result(req, "finished", "true"))
+	})
+}
+
+func TestServiceClientInterfaces(t *testing.T) {
+	now := time.Now()
+	_, cl := heartbeatRouter(t, map[string]time.Time{
+		"ns/s.a":              now,
+		"ns/s.a/i.top":        now,
+		"ns/s.a/dev/sub/i.x":  now,
+		"ns/s.ab/i.elsewhere": now,
+	})
+	sc := cl.NewServiceClient("ns", "s.a")
+	local := sc.AddInterface("local", "i.y")
+	ifaces, err := sc.Interfaces()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, ifc := range ifaces {
+		got = append(got, ifc.prefix+"|"+ifc.name)
+	}
+	want := "dev/sub|i.x |i.top local|i.y"
+	if strings.Join(got, " ") != want {
+		t.Errorf("interfaces are %q, expected %q", strings.Join(got, " "), want)
+	}
+	if ifaces[2] != local {
+		t.Error("the locally added interface was replaced")
+	}
+	again, err := sc.Interfaces()
+	if err != nil || len(again) != len(ifaces) {
+		t.Errorf("querying again gave %d interfaces, %v", len(again), err)
+	}
+}
+
+func TestServiceClientIsAlive(t *testing.T) {
+	now := time.Now()
+	_, cl := heartbeatRouter(t, map[string]time.Time{
+		"ns/s.a":        now,
+		"ns/s.a/p/i.x":  now.Add(-2 * DefaultStaleAfter),
+		"ns/s.b/p/i.up": now,
+	})
+	sc := cl.NewServiceClient("ns", "s.a")
+	tests := []struct {
+		what  string
+		alive func() (bool, error)
+		want  bool
+	}{
+		{"fresh service", sc.IsAlive, true},
+		{"stale interface", sc.AddInterface("p", "i.x").IsAlive, false},
+		{"interface without heartbeat", sc.AddInterface("p", "i.none").IsAlive, false},
+		{"service without heartbeat", cl.NewServiceClient("ns", "s.b").IsAlive, false},
+	}
+	for _, tt := range tests {
+		alive, err := tt.alive()
+		if err != nil || alive != tt.want {
+			t.Errorf("%s: alive is %v, %v", tt.what, alive, err)
+		}
+	}
+	last, err := sc.LastAlive()
+	if err != nil || last.UnixNano() != now.UnixNano() {
+		t.Errorf("last alive is %v, %v, expected %v", last, err, now)
+	}
+}
@@ -0,0 +1,191 @@
+package bw2bind
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+)
+
+// ErrMonitorStopped is returned by WaitSynced if the monitor is stopped
+// while waiting
+var ErrMonitorStopped = errors.New("sync monitor stopped")
+
+// DefaultSyncInterval is how often a SyncMonitor samples the router
+const DefaultSyncInterval = 5 * time.Second
+
+// syncWindow is how many samples are used to estimate the sync rate
+const syncWindow = 12
+
+// SyncSample is the router's blockchain sync state at one point in time
+type SyncSample struct {
+	Time         time.Time
+	CurrentBlock uint64
+	// Zero if the router is not currently catching up
+	HighestBlock int64
+	Peers        int64
+	// The age of the current block
+	CurrentAge time.Duration
+	// CurrentBlock/HighestBlock, or 1 if the router is not catching up
+	Progress float64
+	// Blocks per second imported over recent samples
+	Rate float64
+	// Estimated time to reach HighestBlock at Rate, zero if unknown
+	ETA time.Duration
+}
+
+// SyncMonitor periodically samples GetBCInteractionParams to follow the
+// router's blockchain sync progress
+type SyncMonitor struct {
+	cl       *BW2Client
+	interval time.Duration
+	mu       sync.Mutex
+	samples  []*SyncSample
+	subs     []chan *SyncSample
+	changed  chan struct{}
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// MonitorSync starts sampling the sync state every interval, or every
+// DefaultSyncInterval if it is zero
+func (cl *BW2Client) MonitorSync(interval time.Duration) *SyncMonitor {
+	if interval == 0 {
+		interval = DefaultSyncInterval
+	}
+	rv := &SyncMonitor{
+		cl:       cl,
+		interval: interval,
+		changed:  make(chan struct{}),
+		stop:     make(chan struct{}),
+	}
+	go rv.loop()
+	return rv
+}
+
+func (m *SyncMonitor) loop() {
+	t := time.NewTicker(m.interval)
+	defer t.Stop()
+	defer func() {
+		m.mu.Lock()
+		for _, s := range m.subs {
+			close(s)
+		}
+		m.subs = nil
+		m.mu.Unlock()
+	}()
+	for {
+		m.sample()
+		select {
+		case <-m.stop:
+			return
+		case <-t.C:
+		}
+	}
+}
+
+func (m *SyncMonitor) sample() {
+	cip, err := m.cl.GetBCInteractionParams()
+	if err != nil {
+		log.Warn("could not sample chain sync state: ", err)
+		return
+	}
+	s := &SyncSample{
+		Time:         time.Now(),
+		CurrentBlock: cip.CurrentBlock,
+		HighestBlock: cip.HighestBlock,
+		Peers:        cip.Peers,
+		CurrentAge:   cip.CurrentAge,
+	}
+	m.record(s)
+}
+
+// record fills in the progress, rate and ETA of a new sample from the
+// recent samples, and delivers it
+func (m *SyncMonitor) record(s *SyncSample) {
+	s.Progress = 1
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.samples) > 0 {
+		first := m.samples[0]
+		if dt := s.Time.Sub(first.Time).Seconds(); dt > 0 && s.CurrentBlock >= first.CurrentBlock {
+			s.Rate = float64(s.CurrentBlock-first.CurrentBlock) / dt
+		}
+	}
+	if s.HighestBlock > 0 && uint64(s.HighestBlock) > s.CurrentBlock {
+		s.Progress = float64(s.CurrentBlock) / float64(s.HighestBlock)
+		if s.Rate > 0 {
+			left := float64(uint64(s.HighestBlock) - s.CurrentBlock)
+			s.ETA = time.Duration(left / s.Rate * float64(time.Second))
+		}
+	}
+	m.samples = append(m.samples, s)
+	if len(m.samples) > syncWindow {
+		m.samples = m.samples[1:]
+	}
+	for _, c := range m.subs {
+		select {
+		case c <- s:
+		default:
+		}
+	}
+	close(m.changed)
+	m.changed = make(chan struct{})
+}
+
+// Latest returns the most recent sample, or nil if there is none yet
+func (m *SyncMonitor) Latest() *SyncSample {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.samples) == 0 {
+		return nil
+	}
+	return m.samples[len(m.samples)-1]
+}
+
+// Samples returns a new channel that receives every sample. Samples are
+// dropped if the receiver falls behind. The channel is closed by Stop.
+func (m *SyncMonitor) Samples() <-chan *SyncSample {
+	rv := make(chan *SyncSample, 10)
+	m.mu.Lock()
+	select {
+	case <-m.stop:
+		close(rv)
+	default:
+		m.subs = append(m.subs, rv)
+	}
+	m.mu.Unlock()
+	return rv
+}
+
+// WaitSynced blocks until the router's current block is no older than
+// maxAge, or the context is done. Use it before registry operations that
+// need an up to date chain.
+func (m *SyncMonitor) WaitSynced(ctx context.Context, maxAge time.Duration) error {
+	for {
+		m.mu.Lock()
+		changed := m.changed
+		var last *SyncSample
+		if len(m.samples) > 0 {
+			last = m.samples[len(m.samples)-1]
+		}
+		m.mu.Unlock()
+		if last != nil && last.CurrentAge <= maxAge {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-m.stop:
+			return ErrMonitorStopped
+		case <-changed:
+		}
+	}
+}
+
+// Stop stops sampling and closes the sample channels
+func (m *SyncMonitor) Stop() {
+	m.stopOnce.Do(func() { close(m.stop) })
+}
@@ -0,0 +1,106 @@
+package bw2bind
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func newTestSyncMonitor() *SyncMonitor {
+	return &SyncMonitor{
+		changed: make(chan struct{}),
+		stop:    make(chan struct{}),
+	}
+}
+
+func TestSyncMonitorRecord(t *testing.T) {
+	start := time.Unix(1000000, 0)
+	tests := []struct {
+		name           string
+		current        uint64
+		highest        int64
+		progress, rate float64
+		eta            time.Duration
+	}{
+		// the first sample has no rate
+		{"first sample", 100, 1100, 100.0 / 1100, 0, 0},
+		{"catching up", 110, 1100, 110.0 / 1100, 10, 99 * time.Second},
+		{"caught up", 1100, 1100, 1, 500, 0},
+		{"not catching up", 1200, 0, 1, 1100.0 / 3, 0},
+		// a reorg to a lower block does not give a negative rate
+		{"behind the first sample", 90, 1100, 90.0 / 1100, 0, 0},
+	}
+	m := newTestSyncMonitor()
+	for i, tt := range tests {
+		s := &SyncSample{Time: start.Add(time.Duration(i) * time.Second), CurrentBlock: tt.current, HighestBlock: tt.highest}
+		m.record(s)
+		if s.Progress != tt.progress || s.Rate != tt.rate || s.ETA != tt.eta {
+			t.Errorf("%s: progress %v rate %v ETA %v, expected %v %v %v", tt.name, s.Progress, s.Rate, s.ETA, tt.progress, tt.rate, tt.eta)
+		}
+		if m.Latest() != s {
+			t.Errorf("%s: not the latest sample", tt.name)
+		}
+	}
+}
+
+func TestSyncMonitorWindow(t *testing.T) {
+	start := time.Unix(1000000, 0)
+	m := newTestSyncMonitor()
+	// one block a second, then a burst of 100 blocks a second once the
+	// slow samples have left the window
+	block := uint64(0)
+	for i := 0; i < syncWindow*2; i++ {
+		if i >= syncWindow {
+			block += 100
+		} else {
+			block++
+		}
+		m.record(&SyncSample{Time: start.Add(time.Duration(i) * time.Second), CurrentBlock: block})
+	}
+	if len(m.samples) != syncWindow {
+		t.Errorf("kept %d samples, expected %d", len(m.samples), syncWindow)
+	}
+	if r := m.Latest().Rate; r != 100 {
+		t.Errorf("rate is %v, expected 100 over the window", r)
+	}
+}
+
+func TestSyncMonitorWaitSynced(t *testing.T) {
+	m := newTestSyncMonitor()
+	samples := m.Samples()
+	done := make(chan error, 1)
+	go func() {
+		done <- m.WaitSynced(context.Background(), time.Minute)
+	}()
+	for _, age := range []time.Duration{time.Hour, time.Minute + time.Second} {
+		m.record(&SyncSample{Time: time.Now(), CurrentAge: age})
+		<-samples
+		select {
+		case err := <-done:
+			t.Fatalf("returned %v with the current block %s old", err, age)
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+	m.record(&SyncSample{Time: time.Now(), CurrentAge: time.Minute})
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("returned %v once synced", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("did not return once synced")
+	}
+	if err := m.WaitSynced(context.Background(), time.Minute); err != nil {
+		t.Errorf("returned %v when already synced", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := m.WaitSynced(ctx, time.Second); err != context.DeadlineExceeded {
+		t.Errorf("returned %v when the context expired", err)
+	}
+	m.Stop()
+	if err := m.WaitSynced(context.Background(), time.Second); err != ErrMonitorStopped {
+		t.Errorf("returned %v when stopped", err)
+	}
+}
@@ -0,0 +1,427 @@
+package bw2bind
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/immesys/bw2/crypto"
+	"github.com/immesys/bw2/objects"
+	"github.com/immesys/bw2bind/adps"
+)
+
+// TrustEdge is an access DOT in a TrustGraph
+type TrustEdge struct {
+	Hash        string
+	From        string
+	To          string
+	Namespace   string
+	Suffix      string
+	Permissions string
+	TTL         int
+	Validity    RegistryValidity
+	DOT         *objects.DOT
+}
+
+// URI returns the full URI pattern the DOT grants on
+func (e *TrustEdge) URI() string {
+	return e.Namespace + "/" + e.Suffix
+}
+
+func (e *TrustEdge) String() string {
+	return fmt.Sprintf("%s: %s -> %s %s on %s (ttl %d)", e.Hash, e.From, e.To, e.Permissions, e.URI(), e.TTL)
+}
+
+// TrustChain is a sequence of DOTs leading from a namespace to a VK
+type TrustChain struct {
+	Edges []*TrustEdge
+}
+
+// To returns the VK at the end of the chain
+func (c *TrustChain) To() string {
+	return c.Edges[len(c.Edges)-1].To
+}
+
+// Hashes returns the hashes of the DOTs in the chain, in order
+func (c *TrustChain) Hashes() []string {
+	rv := make([]string, len(c.Edges))
+	for i, e := range c.Edges {
+		rv[i] = e.Hash
+	}
+	return rv
+}
+
+func (c *TrustChain) String() string {
+	parts := []string{c.Edges[0].From}
+	for _, e := range c.Edges {
+		parts = append(parts, e.To)
+	}
+	return strings.Join(parts, " -> ")
+}
+
+// AccessGrant lists the chains by which a VK has access to a URI
+type AccessGrant struct {
+	VK     string
+	Chains []*TrustChain
+}
+
+// RevocationImpact describes what would stop working if a DOT were revoked
+type RevocationImpact struct {
+	DOT *TrustEdge
+	// Edges whose giver would no longer be reachable from the namespace
+	Orphaned []*TrustEdge
+	// VKs that would have no remaining path from the namespace
+	LostVKs []string
+}
+
+// TrustGraph is a client side cache of the access DOTs reachable from a
+// set of VKs, crawled with FindDOTsFromVK. It can answer questions about
+// chains without asking the router for each one. Only valid DOTs are
+// used when looking for chains, but every crawled DOT is kept along with
+// its validity.
+type TrustGraph struct {
+	cl    *BW2Client
+	mu    sync.Mutex
+	edges map[string]*TrustEdge
+	from  map[string][]*TrustEdge
+	// the most hops below each VK that its DOTs have been followed for
+	crawled map[string]int
+}
+
+// NewTrustGraph returns an empty trust graph, populate it with Crawl
+func (cl *BW2Client) NewTrustGraph() *TrustGraph {
+	return &TrustGraph{
+		cl:      cl,
+		edges:   make(map[string]*TrustEdge),
+		from:    make(map[string][]*TrustEdge),
+		crawled: make(map[string]int),
+	}
+}
+
+// resolveVK returns the VK for a base64 VK or a namespace alias
+func (g *TrustGraph) resolveVK(vkOrAlias string) (string, error) {
+	if _, err := FromBase64(vkOrAlias); err == nil {
+		return vkOrAlias, nil
+	}
+	v, zero, err := g.cl.ResolveLongAlias(vkOrAlias)
+	if err != nil {
+		return "", err
+	}
+	if zero {
+		return "", fmt.Errorf("could not resolve %q", vkOrAlias)
+	}
+	return crypto.FmtKey(v), nil
+}
+
+// Crawl follows DOTs granted from the given VK or namespace, and
+// transitively from their receivers, up to maxDepth hops (0 for no limit).
+// The DOTs of a VK are only fetched once, use Reset to discard the cache,
+// but VKs that were reached near the depth limit of an earlier crawl are
+// followed further when a later crawl reaches them with more hops left.
+func (g *TrustGraph) Crawl(start string, maxDepth int) error {
+	vk, err := g.resolveVK(start)
+	if err != nil {
+		return err
+	}
+	type hop struct {
+		vk   string
+		left int
+	}
+	left := maxDepth
+	if maxDepth <= 0 {
+		left = math.MaxInt32
+	}
+	frontier := []hop{{vk, left}}
+	for len(frontier) > 0 {
+		next := []hop{}
+		for _, h := range frontier {
+			g.mu.Lock()
+			prev, fetched := g.crawled[h.vk]
+			if fetched && prev >= h.left {
+				g.mu.Unlock()
+				continue
+			}
+			// a VK that was fetched already has all its DOTs in the graph
+			edges := append([]*TrustEdge{}, g.from[h.vk]...)
+			g.mu.Unlock()
+			if !fetched {
+				dots, validity, err := g.cl.FindDOTsFromVK(h.vk)
+				if err != nil {
+					return err
+				}
+				g.mu.Lock()
+				for i, d := range dots {
+					v := RegistryValidity(StateUnknown)
+					if i < len(validity) {
+						v = validity[i]
+					}
+					if e := g.add(d, v); e != nil {
+						edges = append(edges, e)
+					}
+				}
+				g.mu.Unlock()
+			}
+			g.mu.Lock()
+			g.crawled[h.vk] = h.left
+			g.mu.Unlock()
+			if h.left > 1 {
+				for _, e := range edges {
+					next = append(next, hop{e.To, h.left - 1})
+				}
+			}
+		}
+		frontier = next
+	}
+	return nil
+}
+
+// add inserts the DOT, or updates its validity if it is already known, and
+// returns its edge. It returns nil for permission DOTs.
+func (g *TrustGraph) add(d *objects.DOT, v RegistryValidity) *TrustEdge {
+	if !d.IsAccess() {
+		return nil
+	}
+	return g.addEdge(&TrustEdge{
+		Hash:        crypto.FmtHash(d.GetHash()),
+		From:        crypto.FmtKey(d.GetGiverVK()),
+		To:          crypto.FmtKey(d.GetReceiverVK()),
+		Namespace:   crypto.FmtKey(d.GetAccessURIMVK()),
+		Suffix:      d.GetAccessURISuffix(),
+		Permissions: d.GetPermString(),
+		TTL:         d.GetTTL(),
+		Validity:    v,
+		DOT:         d,
+	})
+}
+
+// addEdge inserts the edge and returns it, or if an edge with its hash is
+// already known, updates that edge's validity and returns it instead
+func (g *TrustGraph) addEdge(e *TrustEdge) *TrustEdge {
+	if known, ok := g.edges[e.Hash]; ok {
+		known.Validity = e.Validity
+		return known
+	}
+	g.edges[e.Hash] = e
+	g.from[e.From] = append(g.from[e.From], e)
+	return e
+}
+
+// Reset discards every crawled DOT
+func (g *TrustGraph) Reset() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.edges = make(map[string]*TrustEdge)
+	g.from = make(map[string][]*TrustEdge)
+	g.crawled = make(map[string]int)
+}
+
+// Edges returns every DOT in the graph, ordered by hash
+func (g *TrustGraph) Edges() []*TrustEdge {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	rv := make([]*TrustEdge, 0, len(g.edges))
+	for _, e := range g.edges {
+		rv = append(rv, e)
+	}
+	sort.Slice(rv, func(i, j int) bool { return rv[i].Hash < rv[j].Hash })
+	return rv
+}
+
+// Edge returns the DOT with the given hash, or nil if it is not in the graph
+func (g *TrustGraph) Edge(hash string) *TrustEdge {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.edges[hash]
+}
+
+// splitURI resolves the namespace of uri and returns it with the suffix
+func (g *TrustGraph) splitURI(uri string) (string, string, error) {
+	parts := strings.SplitN(uri, "/", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return "", "", fmt.Errorf("uri %q has no suffix", uri)
+	}
+	if err := adps.ValidatePattern(uri); err != nil {
+		return "", "", err
+	}
+	ns, err := g.resolveVK(parts[0])
+	if err != nil {
+		return "", "", err
+	}
+	return ns, parts[1], nil
+}
+
+// grants returns true if the edge grants perms on the URI suffix
+func (e *TrustEdge) grants(suffix string, perms adps.Permissions) bool {
+	have, err := adps.Parse(e.Permissions)
+	return err == nil && e.Validity == StateValid && adps.Grants(e.Suffix, have, suffix, perms)
+}
+
+// walk calls fn with every chain from ns that grants perms on suffix,
+// honouring the TTL of each DOT. The graph lock must be held.
+func (g *TrustGraph) walk(ns, suffix string, perms adps.Permissions, fn func(c []*TrustEdge)) {
+	seen := map[string]bool{ns: true}
+	var rec func(vk string, chain []*TrustEdge, ttl int)
+	rec = func(vk string, chain []*TrustEdge, ttl int) {
+		for _, e := range g.from[vk] {
+			if e.Namespace != ns || seen[e.To] || !e.grants(suffix, perms) {
+				continue
+			}
+			nttl := e.TTL
+			if len(chain) > 0 && ttl-1 < nttl {
+				nttl = ttl - 1
+			}
+			c := append(append([]*TrustEdge{}, chain...), e)
+			fn(c)
+			if nttl > 0 {
+				seen[e.To] = true
+				rec(e.To, c, nttl)
+				delete(seen, e.To)
+			}
+		}
+	}
+	rec(ns, nil, 0)
+}
+
+// Chains returns every chain in the graph that grants the permissions on
+// the URI to the given VK, shortest first. The URI may be a pattern, in
+// which case every DOT in the chain must cover all of it.
+func (g *TrustGraph) Chains(uri, permissions, to string) ([]*TrustChain, error) {
+	ns, suffix, err := g.splitURI(uri)
+	if err != nil {
+		return nil, err
+	}
+	perms, err := adps.Parse(permissions)
+	if err != nil {
+		return nil, err
+	}
+	rv := []*TrustChain{}
+	g.mu.Lock()
+	g.walk(ns, suffix, perms, func(c []*TrustEdge) {
+		if c[len(c)-1].To == to {
+			rv = append(rv, &TrustChain{Edges: c})
+		}
+	})
+	g.mu.Unlock()
+	sort.SliceStable(rv, func(i, j int) bool { return len(rv[i].Edges) < len(rv[j].Edges) })
+	return rv, nil
+}
+
+// WhoHasAccess returns every VK in the graph that has a chain granting the
+// permissions on the URI, ordered by VK
+func (g *TrustGraph) WhoHasAccess(uri, permissions string) ([]*AccessGrant, error) {
+	ns, suffix, err := g.splitURI(uri)
+	if err != nil {
+		return nil, err
+	}
+	perms, err := adps.Parse(permissions)
+	if err != nil {
+		return nil, err
+	}
+	byvk := make(map[string]*AccessGrant)
+	g.mu.Lock()
+	g.walk(ns, suffix, perms, func(c []*TrustEdge) {
+		to := c[len(c)-1].To
+		ag, ok := byvk[to]
+		if !ok {
+			ag = &AccessGrant{VK: to}
+			byvk[to] = ag
+		}
+		ag.Chains = append(ag.Chains, &TrustChain{Edges: c})
+	})
+	g.mu.Unlock()
+	rv := make([]*AccessGrant, 0, len(byvk))
+	for _, ag := range byvk {
+		rv = append(rv, ag)
+	}
+	sort.Slice(rv, func(i, j int) bool { return rv[i].VK < rv[j].VK })
+	return rv, nil
+}
+
+// reachable returns the VKs reachable from ns through valid DOTs on ns,
+// not using the DOT with the given hash. The graph lock must be held.
+func (g *TrustGraph) reachable(ns, without string) map[string]bool {
+	rv := map[string]bool{ns: true}
+	queue := []string{ns}
+	for len(queue) > 0 {
+		vk := queue[0]
+		queue = queue[1:]
+		for _, e := range g.from[vk] {
+			if e.Hash == without || e.Namespace != ns || e.Validity != StateValid || rv[e.To] {
+				continue
+			}
+			rv[e.To] = true
+			queue = append(queue, e.To)
+		}
+	}
+	return rv
+}
+
+// RevocationImpact works out which DOTs and VKs in the DOT's namespace
+// would be cut off from the namespace if the DOT were revoked. URIs and
+// permissions are not taken into account, so the result is the upper
+// bound of what could break.
+func (g *TrustGraph) RevocationImpact(hash string) (*RevocationImpact, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	dot, ok := g.edges[hash]
+	if !ok {
+		return nil, errors.New("DOT not in graph")
+	}
+	rv := &RevocationImpact{DOT: dot}
+	before := g.reachable(dot.Namespace, "")
+	after := g.reachable(dot.Namespace, hash)
+	for vk := range before {
+		if !after[vk] {
+			rv.LostVKs = append(rv.LostVKs, vk)
+		}
+	}
+	sort.Strings(rv.LostVKs)
+	for _, e := range g.edges {
+		if e != dot && e.Namespace == dot.Namespace && before[e.From] && !after[e.From] {
+			rv.Orphaned = append(rv.Orphaned, e)
+		}
+	}
+	sort.Slice(rv.Orphaned, func(i, j int) bool { return rv.Orphaned[i].Hash < rv.Orphaned[j].Hash })
+	return rv, nil
+}
+
+// WriteGraphviz writes the graph in Graphviz DOT format. Expired DOTs are
+// drawn grey and revoked DOTs red.
+func (g *TrustGraph) WriteGraphviz(w io.Writer) error {
+	edges := g.Edges()
+	nodes := map[string]bool{}
+	for _, e := range edges {
+		nodes[e.From] = true
+		nodes[e.To] = true
+	}
+	names := make([]string, 0, len(nodes))
+	for vk := range nodes {
+		names = append(names, vk)
+	}
+	sort.Strings(names)
+	b := &strings.Builder{}
+	b.WriteString("digraph trust {\n")
+	for _, vk := range names {
+		fmt.Fprintf(b, "  %q [label=%q];\n", vk, vk[:8])
+	}
+	for _, e := range edges {
+		color := "black"
+		switch e.Validity {
+		case StateExpired:
+			color = "grey"
+		case StateRevoked:
+			color = "red"
+		case StateUnknown, StateError:
+			color = "orange"
+		}
+		label := fmt.Sprintf("%s %s/%s", e.Permissions, e.Namespace[:8], e.Suffix)
+		fmt.Fprintf(b, "  %q -> %q [label=%q, color=%s, tooltip=%q];\n", e.From, e.To, label, color, e.Hash)
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
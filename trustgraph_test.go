@@ -0,0 +1,193 @@
+package bw2bind
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testTrustGraph builds this graph in namespace ns, where a, b, c and d are
+// VKs. Every DOT is valid unless noted.
+//
+//	h1 ns -> a C*P on building/*         ttl 2
+//	h2 a  -> b C   on building/floor1/*  ttl 1
+//	h3 b  -> c C   on building/floor1/*  ttl 0
+//	h4 c  -> d C   on building/*         ttl 5, beyond the TTL of h3
+//	h5 ns -> b P   on building/*         ttl 0
+//	h6 ns -> d C   on building/*         ttl 0, revoked
+//	h7 a  -> d C   on other/*            ttl 0
+//	h8 ns -> c C   on building/*         ttl 0
+func testTrustGraph() (g *TrustGraph, ns, a, b, c, d string) {
+	ns, a, b, c, d = testVK(10), testVK(11), testVK(12), testVK(13), testVK(14)
+	g = (&BW2Client{}).NewTrustGraph()
+	for _, e := range []*TrustEdge{
+		{Hash: "h1", From: ns, To: a, Suffix: "building/*", Permissions: "C*P", TTL: 2},
+		{Hash: "h2", From: a, To: b, Suffix: "building/floor1/*", Permissions: "C", TTL: 1},
+		{Hash: "h3", From: b, To: c, Suffix: "building/floor1/*", Permissions: "C", TTL: 0},
+		{Hash: "h4", From: c, To: d, Suffix: "building/*", Permissions: "C", TTL: 5},
+		{Hash: "h5", From: ns, To: b, Suffix: "building/*", Permissions: "P", TTL: 0},
+		{Hash: "h6", From: ns, To: d, Suffix: "building/*", Permissions: "C", TTL: 0, Validity: StateRevoked},
+		{Hash: "h7", From: a, To: d, Suffix: "other/*", Permissions: "C", TTL: 0},
+		{Hash: "h8", From: ns, To: c, Suffix: "building/*", Permissions: "C", TTL: 0},
+	} {
+		e.Namespace = ns
+		if e.Validity == 0 {
+			e.Validity = StateValid
+		}
+		g.addEdge(e)
+	}
+	return
+}
+
+func chainHashes(cs []*TrustChain) [][]string {
+	rv := [][]string{}
+	for _, c := range cs {
+		rv = append(rv, c.Hashes())
+	}
+	return rv
+}
+
+func TestTrustGraphAddEdge(t *testing.T) {
+	g, ns, a, _, _, _ := testTrustGraph()
+	if n := len(g.Edges()); n != 8 {
+		t.Fatalf("graph has %d edges, expected 8", n)
+	}
+	e := g.addEdge(&TrustEdge{Hash: "h1", From: ns, To: a, Namespace: ns, Validity: StateExpired})
+	if e != g.Edge("h1") || e.Validity != StateExpired || e.Permissions != "C*P" {
+		t.Errorf("re-adding h1 gave %v", e)
+	}
+	if n := len(g.from[ns]); n != 4 {
+		t.Errorf("re-adding h1 left %d edges from the namespace, expected 4", n)
+	}
+	if g.Edge("nope") != nil {
+		t.Error("found an edge that was never added")
+	}
+}
+
+func TestTrustGraphChains(t *testing.T) {
+	g, ns, a, b, c, d := testTrustGraph()
+	room := ns + "/building/floor1/room"
+	tests := []struct {
+		uri, perms, to string
+		want           [][]string
+	}{
+		{room, "C", a, [][]string{{"h1"}}},
+		{room, "C", b, [][]string{{"h1", "h2"}}},
+		// shortest first
+		{room, "C", c, [][]string{{"h8"}, {"h1", "h2", "h3"}}},
+		// h4 is beyond the TTL, h6 is revoked and h7 is on another URI
+		{room, "C", d, [][]string{}},
+		{room, "P", b, [][]string{{"h5"}}},
+		{room, "C*", a, [][]string{{"h1"}}},
+		{room, "C*", b, [][]string{}},
+		{ns + "/building/floor2/room", "C", b, [][]string{}},
+		// consuming on a pattern needs C*, which must cover all of it
+		{ns + "/building/floor1/*", "C", b, [][]string{}},
+		{ns + "/building/floor1/*", "C*", a, [][]string{{"h1"}}},
+		{ns + "/*", "C*", a, [][]string{}},
+	}
+	for _, tt := range tests {
+		cs, err := g.Chains(tt.uri, tt.perms, tt.to)
+		if err != nil {
+			t.Errorf("%s %s to %s: %v", tt.uri, tt.perms, tt.to[:3], err)
+			continue
+		}
+		if got := chainHashes(cs); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s %s to %s: got %v, expected %v", tt.uri, tt.perms, tt.to[:3], got, tt.want)
+		}
+	}
+	if _, err := g.Chains(ns, "C", a); err == nil {
+		t.Error("expected an error for a URI without a suffix")
+	}
+	if _, err := g.Chains(room, "X", a); err == nil {
+		t.Error("expected an error for bad permissions")
+	}
+}
+
+func TestTrustGraphWhoHasAccess(t *testing.T) {
+	g, ns, a, b, c, _ := testTrustGraph()
+	grants, err := g.WhoHasAccess(ns+"/building/floor1/room", "C")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string][][]string{}
+	for _, ag := range grants {
+		got[ag.VK] = chainHashes(ag.Chains)
+	}
+	want := map[string][][]string{
+		a: {{"h1"}},
+		b: {{"h1", "h2"}},
+		c: {{"h1", "h2", "h3"}, {"h8"}},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, expected %v", got, want)
+	}
+	for vk, w := range want {
+		g := got[vk]
+		if len(g) != len(w) {
+			t.Errorf("%s: got %v, expected %v", vk[:3], g, w)
+			continue
+		}
+		for _, wc := range w {
+			found := false
+			for _, gc := range g {
+				found = found || reflect.DeepEqual(gc, wc)
+			}
+			if !found {
+				t.Errorf("%s: missing chain %v in %v", vk[:3], wc, g)
+			}
+		}
+	}
+	for i := 1; i < len(grants); i++ {
+		if grants[i-1].VK > grants[i].VK {
+			t.Error("grants are not ordered by VK")
+		}
+	}
+}
+
+func TestTrustGraphRevocationImpact(t *testing.T) {
+	g, _, a, _, _, _ := testTrustGraph()
+	ri, err := g.RevocationImpact("h1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ri.LostVKs, []string{a}) {
+		t.Errorf("revoking h1 loses %v, expected only a", ri.LostVKs)
+	}
+	orphaned := []string{}
+	for _, e := range ri.Orphaned {
+		orphaned = append(orphaned, e.Hash)
+	}
+	if !reflect.DeepEqual(orphaned, []string{"h2", "h7"}) {
+		t.Errorf("revoking h1 orphans %v, expected h2 and h7", orphaned)
+	}
+	ri, err = g.RevocationImpact("h3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ri.LostVKs) != 0 || len(ri.Orphaned) != 0 {
+		t.Errorf("revoking h3 should not cut anything off as h8 also reaches c, got %v %v", ri.LostVKs, ri.Orphaned)
+	}
+	if _, err := g.RevocationImpact("nope"); err == nil {
+		t.Error("expected an error for a DOT not in the graph")
+	}
+}
+
+func TestTrustGraphGraphviz(t *testing.T) {
+	g, _, _, _, _, _ := testTrustGraph()
+	b := &bytes.Buffer{}
+	if err := g.WriteGraphviz(b); err != nil {
+		t.Fatal(err)
+	}
+	out := b.String()
+	if !strings.HasPrefix(out, "digraph trust {\n") || !strings.HasSuffix(out, "}\n") {
+		t.Errorf("not a digraph:\n%s", out)
+	}
+	if n := strings.Count(out, " -> "); n != 8 {
+		t.Errorf("%d edges drawn, expected 8", n)
+	}
+	if !strings.Contains(out, `color=red, tooltip="h6"`) {
+		t.Errorf("revoked DOT h6 not drawn red:\n%s", out)
+	}
+}
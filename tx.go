@@ -0,0 +1,351 @@
+package bw2bind
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/immesys/bw2/crypto"
+	"github.com/immesys/bw2/objects"
+)
+
+const (
+	// DefaultTxPollInterval is how often a TxHandle polls the block height
+	DefaultTxPollInterval = 5 * time.Second
+	// DefaultTxMaxBlocks is how many blocks a TxHandle waits for the
+	// transaction to become visible before reporting ErrTxNotMined
+	DefaultTxMaxBlocks = 50
+)
+
+// ErrTxNotMined is returned by TxHandle.Wait if the effect of the
+// transaction has not become visible after MaxBlocks blocks
+var ErrTxNotMined = errors.New("transaction was not mined")
+
+// ErrTxUnverifiable is returned by TxHandle.Confirmations and Wait for
+// transactions whose effect cannot be checked for
+var ErrTxUnverifiable = errors.New("transaction cannot be checked for")
+
+// TxHandle tracks a blockchain transaction submitted through the router.
+// The router does not return transaction hashes, so inclusion is detected
+// by checking for the effect of the transaction, e.g. that a published DOT
+// resolves. Transactions whose effect cannot be checked are not
+// Verifiable, and confirmations are never counted for them.
+type TxHandle struct {
+	cl *BW2Client
+	// What the transaction does, for logging
+	Op string
+	// The router's block height when the transaction was submitted
+	SubmitBlock uint64
+	// How often to poll, DefaultTxPollInterval if zero
+	PollInterval time.Duration
+	// How many blocks to wait for the transaction to be visible,
+	// DefaultTxMaxBlocks if zero
+	MaxBlocks uint64
+	// mined returns true once the effect of the transaction is visible
+	mined      func() (bool, error)
+	mu         sync.Mutex
+	minedBlock uint64
+}
+
+func (cl *BW2Client) newTx(op string, mined func() (bool, error)) *TxHandle {
+	rv := &TxHandle{cl: cl, Op: op, mined: mined}
+	if cip, err := cl.GetBCInteractionParams(); err == nil {
+		rv.SubmitBlock = cip.CurrentBlock
+	}
+	return rv
+}
+
+// Verifiable returns true if the transaction's effect can be checked for,
+// so that its confirmations can be counted
+func (tx *TxHandle) Verifiable() bool {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	return tx.mined != nil
+}
+
+// Confirmations returns the number of blocks, including the one it was
+// mined in, that the transaction has. It is zero while the transaction is
+// not visible yet.
+func (tx *TxHandle) Confirmations() (uint64, error) {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	if tx.mined == nil {
+		return 0, ErrTxUnverifiable
+	}
+	cip, err := tx.cl.GetBCInteractionParams()
+	if err != nil {
+		return 0, err
+	}
+	if tx.SubmitBlock == 0 {
+		// the height could not be read at submission
+		tx.SubmitBlock = cip.CurrentBlock
+	}
+	if tx.minedBlock == 0 {
+		ok, err := tx.mined()
+		if err != nil {
+			return 0, err
+		}
+		if !ok {
+			max := tx.MaxBlocks
+			if max == 0 {
+				max = DefaultTxMaxBlocks
+			}
+			if cip.CurrentBlock > tx.SubmitBlock+max {
+				return 0, ErrTxNotMined
+			}
+			return 0, nil
+		}
+		tx.minedBlock = cip.CurrentBlock
+	}
+	if cip.CurrentBlock < tx.minedBlock {
+		return 0, nil
+	}
+	return cip.CurrentBlock - tx.minedBlock + 1, nil
+}
+
+// Wait blocks until the transaction has the given number of confirmations,
+// the context is done, or the transaction is found to have failed. It
+// returns ErrTxNotMined and ErrTxUnverifiable as they are.
+func (tx *TxHandle) Wait(ctx context.Context, confirmations uint64) error {
+	interval := tx.PollInterval
+	if interval == 0 {
+		interval = DefaultTxPollInterval
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		n, err := tx.Confirmations()
+		if err == ErrTxNotMined || err == ErrTxUnverifiable {
+			return err
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", tx.Op, err)
+		}
+		if n >= confirmations {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s: %v after %d of %d confirmations", tx.Op, ctx.Err(), n, confirmations)
+		case <-t.C:
+		}
+	}
+}
+
+// WaitTimeout is Wait with a timeout instead of a context
+func (tx *TxHandle) WaitTimeout(confirmations uint64, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return tx.Wait(ctx, confirmations)
+}
+
+// resolves returns a check that the registry knows the given hash
+func (cl *BW2Client) resolves(hash string) func() (bool, error) {
+	return func() (bool, error) {
+		ro, _, err := cl.ResolveRegistry(hash)
+		return ro != nil, err
+	}
+}
+
+// TransferWeiTx is like TransferWei but returns a handle for the
+// transaction. It is mined once the balance of to has gone up by wei, so
+// it is not Verifiable if that balance cannot be read first.
+func (cl *BW2Client) TransferWeiTx(from int, to string, wei *big.Int) (*TxHandle, error) {
+	var mined func() (bool, error)
+	if bi, err := cl.AddressBalance(to); err == nil {
+		want := bi.Amount().Add(AmountFromWei(wei))
+		mined = func() (bool, error) {
+			bi, err := cl.AddressBalance(to)
+			if err != nil {
+				return false, err
+			}
+			return bi.Amount().Cmp(want) >= 0, nil
+		}
+	}
+	tx := cl.newTx(fmt.Sprintf("transfer %s wei to %s", wei.Text(10), to), mined)
+	if err := cl.TransferWei(from, to, wei); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// CreateLongAliasTx is like CreateLongAlias but returns a handle for the
+// transaction
+func (cl *BW2Client) CreateLongAliasTx(account int, key []byte, val []byte) (*TxHandle, error) {
+	name := string(bytes.TrimRight(key, "\x00"))
+	want := make([]byte, 32)
+	copy(want, val)
+	tx := cl.newTx("create alias "+name, func() (bool, error) {
+		v, _, err := cl.ResolveLongAlias(name)
+		return bytes.Equal(v, want), err
+	})
+	if err := cl.CreateLongAlias(account, key, val); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// PublishDOTWithAccTx is like PublishDOTWithAcc but returns a handle for the
+// transaction as well as the DOT hash
+func (cl *BW2Client) PublishDOTWithAccTx(blob []byte, account int) (string, *TxHandle, error) {
+	tx := cl.newTx("publish DOT", nil)
+	hash, err := cl.PublishDOTWithAcc(blob, account)
+	if err != nil {
+		return "", nil, err
+	}
+	tx.Op += " " + hash
+	tx.mined = cl.resolves(hash)
+	return hash, tx, nil
+}
+
+// PublishEntityWithAccTx is like PublishEntityWithAcc but returns a handle
+// for the transaction as well as the entity VK
+func (cl *BW2Client) PublishEntityWithAccTx(blob []byte, account int) (string, *TxHandle, error) {
+	tx := cl.newTx("publish entity", nil)
+	vk, err := cl.PublishEntityWithAcc(blob, account)
+	if err != nil {
+		return "", nil, err
+	}
+	tx.Op += " " + vk
+	tx.mined = cl.resolves(vk)
+	return vk, tx, nil
+}
+
+// PublishChainWithAccTx is like PublishChainWithAcc but returns a handle for
+// the transaction as well as the chain hash
+func (cl *BW2Client) PublishChainWithAccTx(blob []byte, account int) (string, *TxHandle, error) {
+	tx := cl.newTx("publish chain", nil)
+	hash, err := cl.PublishChainWithAcc(blob, account)
+	if err != nil {
+		return "", nil, err
+	}
+	tx.Op += " " + hash
+	tx.mined = cl.resolves(hash)
+	return hash, tx, nil
+}
+
+// revoked returns a check that the target of a revocation resolves as
+// revoked. The revocation does not say whether its target is a DOT or an
+// entity, so the DOT hash form is looked up first and then the VK form.
+func revoked(target []byte, resolve func(key string) (objects.RoutingObject, RegistryValidity, error)) func() (bool, error) {
+	keys := []string{crypto.FmtHash(target)}
+	if vk := crypto.FmtKey(target); vk != keys[0] {
+		keys = append(keys, vk)
+	}
+	return func() (bool, error) {
+		for _, k := range keys {
+			ro, v, err := resolve(k)
+			if err != nil {
+				return false, err
+			}
+			if ro != nil {
+				return v == StateRevoked, nil
+			}
+		}
+		return false, nil
+	}
+}
+
+// PublishRevocationTx is like PublishRevocation but returns a handle for the
+// transaction as well as the revocation hash. It is mined once the revoked
+// VK or DOT resolves as revoked.
+func (cl *BW2Client) PublishRevocationTx(account int, blob []byte) (string, *TxHandle, error) {
+	var mined func() (bool, error)
+	if ro, err := objects.NewRevocation(objects.RORevocation, blob); err == nil {
+		mined = revoked(ro.(*objects.Revocation).GetTarget(), cl.ResolveRegistry)
+	}
+	tx := cl.newTx("publish revocation", mined)
+	hash, err := cl.PublishRevocation(account, blob)
+	if err != nil {
+		return "", nil, err
+	}
+	tx.Op += " " + hash
+	return hash, tx, nil
+}
+
+// drOffered returns a check that nsvk has an offer from drvk, and if
+// active, that it has been accepted
+func (cl *BW2Client) drOffered(nsvk, drvk string, active bool) func() (bool, error) {
+	return func() (bool, error) {
+		act, _, offers, err := cl.GetDesignatedRouterOffers(nsvk)
+		if err != nil {
+			return false, err
+		}
+		if act == drvk || active {
+			return act == drvk, nil
+		}
+		for _, o := range offers {
+			if o == drvk {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+}
+
+// NewDesignatedRouterOfferTx is like NewDesignatedRouterOffer but returns a
+// handle for the transaction. The offer can only be checked for if dr is
+// given, otherwise the handle is not Verifiable.
+func (cl *BW2Client) NewDesignatedRouterOfferTx(account int, nsvk string, dr *objects.Entity) (*TxHandle, error) {
+	drvk := ""
+	if dr != nil {
+		drvk = crypto.FmtKey(dr.GetVK())
+	}
+	return cl.drOfferTx(account, nsvk, drvk, dr)
+}
+
+// drOfferTx makes the offer from dr, or the current entity if it is nil,
+// and checks for it under drvk unless that is empty
+func (cl *BW2Client) drOfferTx(account int, nsvk, drvk string, dr *objects.Entity) (*TxHandle, error) {
+	var mined func() (bool, error)
+	if drvk != "" {
+		mined = cl.drOffered(nsvk, drvk, false)
+	}
+	tx := cl.newTx("offer designated router for "+nsvk, mined)
+	if err := cl.NewDesignatedRouterOffer(account, nsvk, dr); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// AcceptDesignatedRouterOfferTx is like AcceptDesignatedRouterOffer but
+// returns a handle for the transaction. The acceptance can only be checked
+// for if ns is given, otherwise the handle is not Verifiable.
+func (cl *BW2Client) AcceptDesignatedRouterOfferTx(account int, drvk string, ns *objects.Entity) (*TxHandle, error) {
+	nsvk := ""
+	if ns != nil {
+		nsvk = crypto.FmtKey(ns.GetVK())
+	}
+	return cl.drAcceptTx(account, nsvk, drvk, ns)
+}
+
+// drAcceptTx accepts the offer as ns, or the current entity if it is nil,
+// and checks for it under nsvk unless that is empty
+func (cl *BW2Client) drAcceptTx(account int, nsvk, drvk string, ns *objects.Entity) (*TxHandle, error) {
+	var mined func() (bool, error)
+	if nsvk != "" {
+		mined = cl.drOffered(nsvk, drvk, true)
+	}
+	tx := cl.newTx("accept designated router "+drvk, mined)
+	if err := cl.AcceptDesignatedRouterOffer(account, drvk, ns); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// drSRVTx sets the SRV record of dr, or the current entity if it is nil,
+// and checks for it as the active SRV record of nsvk
+func (cl *BW2Client) drSRVTx(account int, nsvk, drvk, srv string, dr *objects.Entity) (*TxHandle, error) {
+	tx := cl.newTx("set SRV record of "+drvk, func() (bool, error) {
+		_, asrv, _, err := cl.GetDesignatedRouterOffers(nsvk)
+		return asrv == srv, err
+	})
+	if err := cl.SetDesignatedRouterSRVRecord(account, srv, dr); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
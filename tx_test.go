@@ -0,0 +1,251 @@
+package bw2bind
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/immesys/bw2/objects"
+)
+
+// fakeChain is the block height and balances reported by a fake router
+type fakeChain struct {
+	mu       sync.Mutex
+	block    uint64
+	balances map[string]*big.Int
+}
+
+func newFakeChain(t *testing.T, block uint64) (*fakeChain, *BW2Client) {
+	fc := &fakeChain{block: block, balances: make(map[string]*big.Int)}
+	_, cl := newFakeRouter(t, func(req *frame, send func(*frame)) {
+		fc.mu.Lock()
+		defer fc.mu.Unlock()
+		switch req.Cmd {
+		case cmdBCInteractionParams:
+			send(okay(req, "currentblock", strconv.FormatUint(fc.block, 10)))
+		case cmdAddressBalance:
+			addr, _ := req.GetFirstHeader("address")
+			bal, ok := fc.balances[addr]
+			if !ok {
+				send(failed(req, "unknown address"))
+				return
+			}
+			rsp := okay(req)
+			rsp.AddPayloadObject(CreateStringPayloadObject(addr + "," + bal.Text(10) + ",?"))
+			send(rsp)
+		default:
+			send(okay(req))
+		}
+	})
+	return fc, cl
+}
+
+// mine advances the chain by n blocks
+func (fc *fakeChain) mine(n uint64) {
+	fc.mu.Lock()
+	fc.block += n
+	fc.mu.Unlock()
+}
+
+func (fc *fakeChain) setBalance(addr string, wei int64) {
+	fc.mu.Lock()
+	fc.balances[addr] = big.NewInt(wei)
+	fc.mu.Unlock()
+}
+
+// flag is a mined predicate that the test can flip
+type flag struct {
+	mu  sync.Mutex
+	set bool
+	err error
+}
+
+func (f *flag) mined() (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.set, f.err
+}
+
+func (f *flag) update(set bool, err error) {
+	f.mu.Lock()
+	f.set, f.err = set, err
+	f.mu.Unlock()
+}
+
+func TestTxConfirmations(t *testing.T) {
+	fc, cl := newFakeChain(t, 100)
+	f := &flag{}
+	tx := cl.newTx("test", f.mined)
+	tx.MaxBlocks = 5
+	if tx.SubmitBlock != 100 || !tx.Verifiable() {
+		t.Fatalf("submitted at %d, verifiable %v", tx.SubmitBlock, tx.Verifiable())
+	}
+	steps := []struct {
+		name  string
+		mine  uint64
+		set   bool
+		err   error
+		confs uint64
+		fails error
+	}{
+		{"not mined yet", 0, false, nil, 0, nil},
+		{"still waiting", 3, false, nil, 0, nil},
+		{"lookup failed", 0, false, errors.New("router down"), 0, errors.New("router down")},
+		{"mined", 1, true, nil, 1, nil},
+		{"confirmed", 2, true, nil, 3, nil},
+		// once mined, the predicate is not consulted again
+		{"predicate no longer true", 10, false, nil, 13, nil},
+	}
+	for _, s := range steps {
+		fc.mine(s.mine)
+		f.update(s.set, s.err)
+		n, err := tx.Confirmations()
+		if (err == nil) != (s.fails == nil) || n != s.confs {
+			t.Errorf("%s: %d confirmations, %v", s.name, n, err)
+		}
+	}
+
+	late := cl.newTx("late", (&flag{}).mined)
+	late.MaxBlocks = 5
+	fc.mine(5)
+	if n, err := late.Confirmations(); n != 0 || err != nil {
+		t.Errorf("at MaxBlocks: %d confirmations, %v", n, err)
+	}
+	fc.mine(1)
+	if _, err := late.Confirmations(); err != ErrTxNotMined {
+		t.Errorf("after MaxBlocks: %v", err)
+	}
+}
+
+func TestTxUnverifiable(t *testing.T) {
+	_, cl := newFakeChain(t, 100)
+	tx := cl.newTx("unverifiable", nil)
+	if tx.Verifiable() {
+		t.Error("a transaction without a predicate is verifiable")
+	}
+	if _, err := tx.Confirmations(); err != ErrTxUnverifiable {
+		t.Errorf("Confirmations returned %v", err)
+	}
+	if err := tx.WaitTimeout(1, time.Second); err != ErrTxUnverifiable {
+		t.Errorf("Wait returned %v", err)
+	}
+}
+
+func TestTxWait(t *testing.T) {
+	fc, cl := newFakeChain(t, 100)
+	f := &flag{}
+	tx := cl.newTx("wait", f.mined)
+	tx.PollInterval = time.Millisecond
+	done := make(chan error, 1)
+	go func() {
+		done <- tx.Wait(context.Background(), 3)
+	}()
+	f.update(true, nil)
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-done:
+			t.Fatalf("returned %v before 3 confirmations", err)
+		case <-time.After(20 * time.Millisecond):
+		}
+		fc.mine(1)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("returned %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("did not return after 3 confirmations")
+	}
+
+	pending := cl.newTx("pending", (&flag{}).mined)
+	pending.PollInterval = time.Millisecond
+	if err := pending.WaitTimeout(1, 20*time.Millisecond); err == nil || !strings.Contains(err.Error(), "deadline exceeded") {
+		t.Errorf("timed out wait returned %v", err)
+	}
+	pending.MaxBlocks = 1
+	fc.mine(2)
+	if err := pending.WaitTimeout(1, time.Second); err != ErrTxNotMined {
+		t.Errorf("wait past MaxBlocks returned %v", err)
+	}
+	failing := cl.newTx("failing", (&flag{err: errors.New("router down")}).mined)
+	if err := failing.WaitTimeout(1, time.Second); err == nil || !strings.Contains(err.Error(), "failing: router down") {
+		t.Errorf("wait with a failing predicate returned %v", err)
+	}
+}
+
+func TestTransferWeiTx(t *testing.T) {
+	addr := strings.Repeat("ab", 20)
+	fc, cl := newFakeChain(t, 100)
+	fc.setBalance(addr, 1000)
+	tx, err := cl.TransferWeiTx(0, addr, big.NewInt(500))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tx.Verifiable() {
+		t.Fatal("transfer to a readable address is not verifiable")
+	}
+	for _, s := range []struct {
+		balance int64
+		confs   uint64
+	}{{1000, 0}, {1499, 0}, {1500, 1}} {
+		fc.setBalance(addr, s.balance)
+		if n, err := tx.Confirmations(); err != nil || n != s.confs {
+			t.Errorf("balance %d: %d confirmations, %v", s.balance, n, err)
+		}
+	}
+
+	unknown := strings.Repeat("cd", 20)
+	tx, err = cl.TransferWeiTx(0, unknown, big.NewInt(500))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tx.Verifiable() {
+		t.Error("transfer to an address without a readable balance is verifiable")
+	}
+}
+
+func TestRevoked(t *testing.T) {
+	target := []byte("0123456789abcdef0123456789abcdef")
+	registry := map[string]RegistryValidity{}
+	var lookupErr error
+	check := revoked(target, func(key string) (objects.RoutingObject, RegistryValidity, error) {
+		if lookupErr != nil {
+			return nil, StateError, lookupErr
+		}
+		v, ok := registry[key]
+		if !ok {
+			return nil, StateUnknown, nil
+		}
+		return &fakeRO{}, v, nil
+	})
+	key := ToBase64(target)
+	for _, s := range []struct {
+		name    string
+		state   RegistryValidity
+		known   bool
+		err     error
+		revoked bool
+	}{
+		{"unknown target", 0, false, nil, false},
+		{"valid", StateValid, true, nil, false},
+		{"expired", StateExpired, true, nil, false},
+		{"revoked", StateRevoked, true, nil, true},
+		{"lookup failed", StateRevoked, true, errors.New("router down"), false},
+	} {
+		delete(registry, key)
+		if s.known {
+			registry[key] = s.state
+		}
+		lookupErr = s.err
+		ok, err := check()
+		if ok != s.revoked || (err != nil) != (s.err != nil) {
+			t.Errorf("%s: revoked %v, %v", s.name, ok, err)
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package bw2bind
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// The metadata keys that an Interface publishes its declared slots and
+// signals under. The value is a comma separated list of name:PODotForm
+const (
+	SlotsMetadataKey   = "slots"
+	SignalsMetadataKey = "signals"
+)
+
+// InterfaceSchema is the set of slots and signals an interface declares,
+// mapping each slot or signal name to the PO number it carries
+type InterfaceSchema struct {
+	Slots   map[string]int
+	Signals map[string]int
+}
+
+// CreatePayloadObjectFromValue encodes v as a payload object of the given
+// type. The PO number must be in the MsgPack, JSON or YAML classes.
+func CreatePayloadObjectFromValue(ponum int, v interface{}) (PayloadObject, error) {
+	switch ponum >> 24 {
+	case PONumMsgPack >> 24:
+		return CreateMsgPackPayloadObject(ponum, v)
+	case PONumYAML >> 24:
+		return CreateYAMLPayloadObject(ponum, v)
+	case PONumJSON >> 24:
+		contents, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		return CreateTextPayloadObject(ponum, string(contents)), nil
+	}
+	return nil, fmt.Errorf("PO %s has no known encoding", PONumDotForm(ponum))
+}
+
+func hasValueEncoding(ponum int) bool {
+	switch ponum >> 24 {
+	case PONumMsgPack >> 24, PONumYAML >> 24, PONumJSON >> 24:
+		return true
+	}
+	return false
+}
+
+// PayloadObjectValueInto decodes a MsgPack, JSON or YAML payload object into v
+func PayloadObjectValueInto(po PayloadObject, v interface{}) error {
+	if po.IsTypeDF(PODFMaskJSON) {
+		return json.Unmarshal(po.GetContents(), v)
+	}
+	if vpo, ok := po.(interface {
+		ValueInto(v interface{}) error
+	}); ok {
+		return vpo.ValueInto(v)
+	}
+	return fmt.Errorf("PO %s has no known encoding", po.GetPODotNum())
+}
+
+// typedHandler adapts a func(T) or func(T, *SimpleMessage) into a function
+// that decodes the first PO of the given type into a new T and calls it
+func typedHandler(ponum int, handler interface{}) (func(*SimpleMessage) error, error) {
+	hv := reflect.ValueOf(handler)
+	if !hv.IsValid() {
+		return nil, errors.New("handler must be a func(T) or func(T, *SimpleMessage)")
+	}
+	ht := hv.Type()
+	if ht.Kind() != reflect.Func || ht.NumOut() != 0 || ht.NumIn() < 1 || ht.NumIn() > 2 ||
+		(ht.NumIn() == 2 && ht.In(1) != reflect.TypeOf(&SimpleMessage{})) {
+		return nil, errors.New("handler must be a func(T) or func(T, *SimpleMessage)")
+	}
+	at := ht.In(0)
+	df := PONumDotForm(ponum)
+	return func(sm *SimpleMessage) error {
+		po := sm.GetOnePODF(df)
+		if po == nil {
+			return fmt.Errorf("message on %s has no PO %s", sm.URI, df)
+		}
+		var arg reflect.Value
+		if at.Kind() == reflect.Ptr {
+			arg = reflect.New(at.Elem())
+		} else {
+			arg = reflect.New(at)
+		}
+		if err := PayloadObjectValueInto(po, arg.Interface()); err != nil {
+			return fmt.Errorf("could not decode PO %s on %s: %v", df, sm.URI, err)
+		}
+		if at.Kind() != reflect.Ptr {
+			arg = arg.Elem()
+		}
+		args := []reflect.Value{arg}
+		if ht.NumIn() == 2 {
+			args = append(args, reflect.ValueOf(sm))
+		}
+		hv.Call(args)
+		return nil
+	}, nil
+}
+
+func (ifc *Interface) declare(isSlot bool, name string, ponum int) error {
+	ifc.svc.mu.Lock()
+	if ifc.schema.Slots == nil {
+		ifc.schema.Slots = make(map[string]int)
+		ifc.schema.Signals = make(map[string]int)
+	}
+	key, val := SignalsMetadataKey, ""
+	if isSlot {
+		key = SlotsMetadataKey
+		ifc.schema.Slots[name] = ponum
+		val = formatSchemaEntries(ifc.schema.Slots)
+	} else {
+		ifc.schema.Signals[name] = ponum
+		val = formatSchemaEntries(ifc.schema.Signals)
+	}
+	ifc.svc.mu.Unlock()
+	return ifc.SetMetadata(key, val)
+}
+
+// DeclareSlot records that the given slot accepts the given PO type and
+// publishes the updated slot list as interface metadata
+func (ifc *Interface) DeclareSlot(slot string, ponum int) error {
+	return ifc.declare(true, slot, ponum)
+}
+
+// DeclareSignal records that the given signal carries the given PO type and
+// publishes the updated signal list as interface metadata
+func (ifc *Interface) DeclareSignal(signal string, ponum int) error {
+	return ifc.declare(false, signal, ponum)
+}
+
+// Schema returns the slots and signals declared on this interface
+func (ifc *Interface) Schema() *InterfaceSchema {
+	ifc.svc.mu.Lock()
+	defer ifc.svc.mu.Unlock()
+	rv := &InterfaceSchema{Slots: make(map[string]int), Signals: make(map[string]int)}
+	for k, v := range ifc.schema.Slots {
+		rv.Slots[k] = v
+	}
+	for k, v := range ifc.schema.Signals {
+		rv.Signals[k] = v
+	}
+	return rv
+}
+
+// SubscribeSlotAs declares the slot as carrying the given PO type and
+// subscribes to it. For every message, the first PO of that type is decoded
+// into a new value of the handler's argument type, which must be a func(T)
+// or func(T, *SimpleMessage). Messages that cannot be decoded are reported
+// to the service error handler.
+func (ifc *Interface) SubscribeSlotAs(slot string, ponum int, handler interface{}) error {
+	cb, err := typedHandler(ponum, handler)
+	if err != nil {
+		return err
+	}
+	if err := ifc.DeclareSlot(slot, ponum); err != nil {
+		return err
+	}
+	return ifc.SubscribeSlot(slot, func(sm *SimpleMessage) {
+		if err := cb(sm); err != nil {
+			ifc.svc.life.handlerError(err)
+		}
+	})
+}
+
+// SignalPublisher publishes values on a signal, encoding them as a fixed
+// PO type
+type SignalPublisher struct {
+	ifc    *Interface
+	signal string
+	ponum  int
+}
+
+// NewSignalPublisher declares the signal as carrying the given PO type
+// and returns a publisher for it
+func (ifc *Interface) NewSignalPublisher(signal string, ponum int) (*SignalPublisher, error) {
+	if !hasValueEncoding(ponum) {
+		return nil, fmt.Errorf("PO %s has no known encoding", PONumDotForm(ponum))
+	}
+	if err := ifc.DeclareSignal(signal, ponum); err != nil {
+		return nil, err
+	}
+	return &SignalPublisher{ifc: ifc, signal: signal, ponum: ponum}, nil
+}
+
+// Publish encodes v as the publisher's PO type and publishes it on the signal
+func (sp *SignalPublisher) Publish(v interface{}) error {
+	po, err := CreatePayloadObjectFromValue(sp.ponum, v)
+	if err != nil {
+		return err
+	}
+	return sp.ifc.PublishSignal(sp.signal, po)
+}
+
+// PublishSlotAs encodes v as the given PO type and publishes it on the slot
+func (ifclient *InterfaceClient) PublishSlotAs(slot string, ponum int, v interface{}) error {
+	po, err := CreatePayloadObjectFromValue(ponum, v)
+	if err != nil {
+		return err
+	}
+	return ifclient.PublishSlot(slot, po)
+}
+
+// SubscribeSignalAs is the client side equivalent of Interface.SubscribeSlotAs.
+// Messages that cannot be decoded are reported to the client error handler.
+func (ifclient *InterfaceClient) SubscribeSignalAs(signal string, ponum int, handler interface{}) error {
+	cb, err := typedHandler(ponum, handler)
+	if err != nil {
+		return err
+	}
+	return ifclient.SubscribeSignal(signal, func(sm *SimpleMessage) {
+		if err := cb(sm); err != nil {
+			ifclient.sc.life.handlerError(err)
+		}
+	})
+}
+
+// GetSchema reads the slots and signals that the interface has declared
+// from its metadata
+func (ifclient *InterfaceClient) GetSchema() (*InterfaceSchema, error) {
+	md, _, err := ifclient.sc.cl.GetMetadata(ifclient.FullURI())
+	if err != nil {
+		return nil, err
+	}
+	return ParseInterfaceSchema(md)
+}
+
+// ParseInterfaceSchema extracts the declared slots and signals from the
+// metadata of an interface
+func ParseInterfaceSchema(md map[string]*MetadataTuple) (*InterfaceSchema, error) {
+	rv := &InterfaceSchema{}
+	var err error
+	if rv.Slots, err = parseSchemaEntries(md[SlotsMetadataKey]); err != nil {
+		return nil, err
+	}
+	if rv.Signals, err = parseSchemaEntries(md[SignalsMetadataKey]); err != nil {
+		return nil, err
+	}
+	return rv, nil
+}
+
+func formatSchemaEntries(m map[string]int) string {
+	ents := make([]string, 0, len(m))
+	for name, ponum := range m {
+		ents = append(ents, name+":"+PONumDotForm(ponum))
+	}
+	sort.Strings(ents)
+	return strings.Join(ents, ",")
+}
+
+func parseSchemaEntries(mt *MetadataTuple) (map[string]int, error) {
+	rv := make(map[string]int)
+	if mt == nil || mt.Value == "" {
+		return rv, nil
+	}
+	for _, ent := range strings.Split(mt.Value, ",") {
+		parts := strings.SplitN(ent, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("malformed schema entry %q", ent)
+		}
+		ponum, err := PONumFromDotForm(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		rv[parts[0]] = ponum
+	}
+	return rv, nil
+}
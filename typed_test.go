@@ -0,0 +1,126 @@
+package bw2bind
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type typedTestValue struct {
+	Name  string
+	Count int
+}
+
+func TestPayloadObjectValueRoundTrip(t *testing.T) {
+	in := typedTestValue{Name: "lamp", Count: 3}
+	for _, ponum := range []int{PONumMsgPack, PONumJSON, PONumYAML, PONumSMetadata} {
+		po, err := CreatePayloadObjectFromValue(ponum, in)
+		if err != nil {
+			t.Errorf("%s: %v", PONumDotForm(ponum), err)
+			continue
+		}
+		if po.GetPONum() != ponum {
+			t.Errorf("%s: created PO %s", PONumDotForm(ponum), po.GetPODotNum())
+		}
+		var out typedTestValue
+		if err := PayloadObjectValueInto(po, &out); err != nil {
+			t.Errorf("%s: %v", PONumDotForm(ponum), err)
+			continue
+		}
+		if out != in {
+			t.Errorf("%s: decoded %+v, expected %+v", PONumDotForm(ponum), out, in)
+		}
+	}
+	if _, err := CreatePayloadObjectFromValue(PONumDouble, 1.5); err == nil {
+		t.Error("encoded a value as a PO type without a value encoding")
+	}
+	var s string
+	if err := PayloadObjectValueInto(CreateStringPayloadObject("x"), &s); err == nil {
+		t.Error("decoded a value from a PO type without a value encoding")
+	}
+}
+
+func TestSchemaEntries(t *testing.T) {
+	schema := &InterfaceSchema{
+		Slots:   map[string]int{"state": PONumMsgPack, "brightness": PONumJSON},
+		Signals: map[string]int{},
+	}
+	slots := formatSchemaEntries(schema.Slots)
+	if slots != "brightness:65.0.0.0,state:2.0.0.0" {
+		t.Errorf("slots formatted as %q", slots)
+	}
+	got, err := ParseInterfaceSchema(map[string]*MetadataTuple{
+		SlotsMetadataKey:   {Value: slots},
+		SignalsMetadataKey: {Value: formatSchemaEntries(schema.Signals)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, schema) {
+		t.Errorf("parsed %+v, expected %+v", got, schema)
+	}
+	got, err = ParseInterfaceSchema(map[string]*MetadataTuple{})
+	if err != nil || len(got.Slots) != 0 || len(got.Signals) != 0 {
+		t.Errorf("parsing no metadata gave %+v, %v", got, err)
+	}
+	for _, bad := range []string{"state", "state:2.0.0", "state:2.0.0.0,", "state:a.b.c.d"} {
+		if _, err := ParseInterfaceSchema(map[string]*MetadataTuple{SignalsMetadataKey: {Value: bad}}); err == nil {
+			t.Errorf("parsed malformed schema %q", bad)
+		}
+	}
+}
+
+func TestTypedHandler(t *testing.T) {
+	po, err := CreatePayloadObjectFromValue(PONumMsgPack, typedTestValue{Name: "lamp", Count: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sm := &SimpleMessage{URI: "a/b", POs: []PayloadObject{po}}
+
+	var byValue typedTestValue
+	cb, err := typedHandler(PONumMsgPack, func(v typedTestValue) { byValue = v })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cb(sm); err != nil || byValue.Count != 3 {
+		t.Errorf("func(T) handler got %+v, %v", byValue, err)
+	}
+
+	var byPointer *typedTestValue
+	var gotMsg *SimpleMessage
+	cb, err = typedHandler(PONumMsgPack, func(v *typedTestValue, m *SimpleMessage) { byPointer, gotMsg = v, m })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cb(sm); err != nil || byPointer == nil || byPointer.Name != "lamp" || gotMsg != sm {
+		t.Errorf("func(*T, *SimpleMessage) handler got %+v %v, %v", byPointer, gotMsg, err)
+	}
+
+	cb, err = typedHandler(PONumJSON, func(v typedTestValue) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cb(sm); err == nil || !strings.Contains(err.Error(), "has no PO 65.0.0.0") {
+		t.Errorf("message without the PO gave %v", err)
+	}
+	cb, err = typedHandler(PONumMsgPack, func(v int) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cb(sm); err == nil {
+		t.Error("decoded a map into an int")
+	}
+
+	for _, bad := range []interface{}{
+		nil,
+		42,
+		func() {},
+		func(v int) error { return nil },
+		func(v int, s string) {},
+		func(v int, m *SimpleMessage, x int) {},
+	} {
+		if _, err := typedHandler(PONumMsgPack, bad); err == nil {
+			t.Errorf("accepted handler %T", bad)
+		}
+	}
+}
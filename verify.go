@@ -0,0 +1,331 @@
+package bw2bind
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/immesys/bw2/crypto"
+	"github.com/immesys/bw2/objects"
+	"github.com/immesys/bw2bind/adps"
+)
+
+// ErrNotPacked is returned by Verify for messages that do not include the
+// packed message, i.e. that were not received with LeavePacked
+var ErrNotPacked = errors.New("message is not packed, subscribe or query with LeavePacked")
+
+// MessageCheck is what Verify found out about a message
+type MessageCheck struct {
+	// The VK that signed the message
+	Signer string
+	// The namespace VK of the message URI
+	Namespace string
+	// The full URI, with the namespace as a VK
+	URI string
+	// The hash of the chain authorising the message, empty if the
+	// namespace signed it directly
+	ChainHash string
+	// The DOTs in the chain, from the namespace to the signer
+	DOTHashes []string
+	// The permissions the whole chain grants
+	Permissions string
+	// The earliest expiry of any DOT in the chain, nil if none expire
+	Expiry    *time.Time
+	MessageID uint64
+}
+
+// packedMessage is a BOSSWAVE message in its signed wire form, as carried
+// in a BWMessage PO:
+//
+//	type          1 byte
+//	message id    8 bytes
+//	consumers     2 bytes
+//	namespace VK  32 bytes
+//	suffix length 2 bytes, then the URI suffix
+//	RO count      1 byte
+//	PO count      1 byte
+//	each RO       1 byte type, 2 byte length, content
+//	each PO       4 byte type, 4 byte length, content
+//	signature     64 bytes, over everything before it
+//
+// Integers are little endian.
+type packedMessage struct {
+	Type      byte
+	MessageID uint64
+	Consumers uint16
+	MVK       []byte
+	Suffix    string
+	ROs       []packedObject
+	POs       []packedObject
+	Signed    []byte
+	Signature []byte
+}
+
+type packedObject struct {
+	Num     int
+	Content []byte
+}
+
+func decodePacked(buf []byte) (*packedMessage, error) {
+	short := errors.New("packed message is truncated")
+	if len(buf) < 45+2+ed25519.SignatureSize {
+		return nil, short
+	}
+	body := buf[:len(buf)-ed25519.SignatureSize]
+	rv := &packedMessage{
+		Type:      body[0],
+		MessageID: binary.LittleEndian.Uint64(body[1:]),
+		Consumers: binary.LittleEndian.Uint16(body[9:]),
+		MVK:       body[11:43],
+		Signed:    body,
+		Signature: buf[len(body):],
+	}
+	idx := 45
+	sl := int(binary.LittleEndian.Uint16(body[43:]))
+	if len(body) < idx+sl+2 {
+		return nil, short
+	}
+	rv.Suffix = string(body[idx : idx+sl])
+	idx += sl
+	nros, npos := int(body[idx]), int(body[idx+1])
+	idx += 2
+	for i := 0; i < nros; i++ {
+		if len(body) < idx+3 {
+			return nil, short
+		}
+		num, ln := int(body[idx]), int(binary.LittleEndian.Uint16(body[idx+1:]))
+		idx += 3
+		if len(body) < idx+ln {
+			return nil, short
+		}
+		rv.ROs = append(rv.ROs, packedObject{num, body[idx : idx+ln]})
+		idx += ln
+	}
+	for i := 0; i < npos; i++ {
+		if len(body) < idx+8 {
+			return nil, short
+		}
+		num, ln := int(binary.LittleEndian.Uint32(body[idx:])), int(binary.LittleEndian.Uint32(body[idx+4:]))
+		idx += 8
+		if ln < 0 || len(body)-idx < ln {
+			return nil, short
+		}
+		rv.POs = append(rv.POs, packedObject{num, body[idx : idx+ln]})
+		idx += ln
+	}
+	if idx != len(body) {
+		return nil, fmt.Errorf("packed message has %d trailing bytes", len(body)-idx)
+	}
+	return rv, nil
+}
+
+// verifySignature checks the Ed25519 signature of the message against vk
+func (pm *packedMessage) verifySignature(vk []byte) error {
+	if len(vk) != ed25519.PublicKeySize {
+		return fmt.Errorf("signer VK %q is not %d bytes", crypto.FmtKey(vk), ed25519.PublicKeySize)
+	}
+	if !ed25519.Verify(ed25519.PublicKey(vk), pm.Signed, pm.Signature) {
+		return fmt.Errorf("message signature is not valid for %s", crypto.FmtKey(vk))
+	}
+	return nil
+}
+
+// Verify checks a message received with LeavePacked without contacting
+// the router. It decodes the packed message, checks its Ed25519 signature
+// against the origin VK, and checks that the DOT chain included in it is
+// validly signed, unexpired, within the TTL of every DOT, leads from the
+// URI's namespace to the signer and grants publish on the URI. The chain
+// must be elaborated or its DOTs included as routing objects.
+//
+// Revocations cannot be seen offline, use BW2Client.VerifyMessage to also
+// check the chain against the registry.
+func (sm *SimpleMessage) Verify() (*MessageCheck, error) {
+	return sm.verify(nil)
+}
+
+// VerifyMessage is SimpleMessage.Verify, but also fails if any DOT in the
+// chain is revoked or expired in the registry
+func (cl *BW2Client) VerifyMessage(sm *SimpleMessage) (*MessageCheck, error) {
+	return sm.verify(func(hash string) (RegistryValidity, error) {
+		_, v, err := cl.ResolveRegistry(hash)
+		return v, err
+	})
+}
+
+func (sm *SimpleMessage) verify(resolve func(hash string) (RegistryValidity, error)) (*MessageCheck, error) {
+	po := sm.GetOnePODF(PODFBWMessage)
+	if po == nil {
+		return nil, ErrNotPacked
+	}
+	pm, err := decodePacked(po.GetContents())
+	if err != nil {
+		return nil, err
+	}
+	if len(sm.Signature) != 0 && !bytes.Equal(sm.Signature, pm.Signature) {
+		return nil, errors.New("message signature does not match the packed message")
+	}
+	var origin []byte
+	var chain *objects.DChain
+	dots := make(map[string]*objects.DOT)
+	for _, pro := range pm.ROs {
+		if pro.Num == objects.ROOriginVK {
+			origin = pro.Content
+			continue
+		}
+		ro, err := objects.LoadRoutingObject(pro.Num, pro.Content)
+		if err != nil || ro == nil {
+			continue
+		}
+		switch r := ro.(type) {
+		case *objects.DChain:
+			if chain == nil {
+				chain = r
+			}
+		case *objects.DOT:
+			dots[crypto.FmtHash(r.GetHash())] = r
+		}
+	}
+	nsvk := crypto.FmtKey(pm.MVK)
+	rv := &MessageCheck{Namespace: nsvk, URI: nsvk + "/" + pm.Suffix, MessageID: pm.MessageID}
+	var links []chainDOT
+	if chain != nil {
+		rv.ChainHash = crypto.FmtHash(chain.GetChainHash())
+		for i := 0; i < chain.NumHashes(); i++ {
+			hash := crypto.FmtHash(chain.GetDotHash(i))
+			rv.DOTHashes = append(rv.DOTHashes, hash)
+			var d *objects.DOT
+			if chain.IsElaborated() {
+				d = chain.GetDOT(i)
+			}
+			if d == nil {
+				d = dots[hash]
+			}
+			if d == nil {
+				return rv, fmt.Errorf("DOT %s of the chain is not included in the message", hash)
+			}
+			if !bytes.Equal(d.GetHash(), chain.GetDotHash(i)) {
+				return rv, fmt.Errorf("DOT %s does not match the chain", hash)
+			}
+			links = append(links, d)
+		}
+	}
+	if origin == nil {
+		if len(links) == 0 {
+			return rv, errors.New("message has neither an origin VK nor a DOT chain")
+		}
+		origin = links[len(links)-1].GetReceiverVK()
+	}
+	rv.Signer = crypto.FmtKey(origin)
+	if sm.From != "" && sm.From != rv.Signer {
+		return rv, fmt.Errorf("message claims to be from %s but is signed by %s", sm.From, rv.Signer)
+	}
+	if err := pm.verifySignature(origin); err != nil {
+		return rv, err
+	}
+	if rv.Signer == nsvk && chain == nil {
+		// the namespace may publish on itself without a chain
+		rv.Permissions = adps.Permissions{Consume: adps.Star, Tap: adps.Star, Publish: true, List: true}.String()
+		return rv, nil
+	}
+	if chain == nil {
+		return rv, errors.New("message has no DOT chain")
+	}
+	return rv, checkChain(rv, pm.Suffix, links, time.Now(), resolve)
+}
+
+// chainDOT is the part of a DOT that checkChain needs
+type chainDOT interface {
+	GetHash() []byte
+	IsAccess() bool
+	SigValid() bool
+	GetGiverVK() []byte
+	GetReceiverVK() []byte
+	GetTTL() int
+	GetExpiry() *time.Time
+	GetPermString() string
+	GetAccessURIMVK() []byte
+	GetAccessURISuffix() string
+}
+
+// checkChain checks that the DOTs lead from the namespace to the signer in
+// rv, granting publish on the URI suffix at now, and fills in the
+// permissions and expiry. If resolve is not nil, each DOT must also be
+// valid in the registry.
+func checkChain(rv *MessageCheck, suffix string, dots []chainDOT, now time.Time, resolve func(hash string) (RegistryValidity, error)) error {
+	if len(dots) == 0 {
+		return errors.New("DOT chain is empty")
+	}
+	perms := adps.Permissions{Consume: adps.Star, Tap: adps.Star, Publish: true, List: true}
+	from := rv.Namespace
+	ttl := 0
+	for i, d := range dots {
+		hash := crypto.FmtHash(d.GetHash())
+		if !d.IsAccess() || !d.SigValid() {
+			return fmt.Errorf("DOT %s is not a validly signed access DOT", hash)
+		}
+		if giver := crypto.FmtKey(d.GetGiverVK()); giver != from {
+			return fmt.Errorf("DOT %s is granted by %s, expected %s", hash, giver, from)
+		}
+		from = crypto.FmtKey(d.GetReceiverVK())
+		// each DOT may be followed by as many more as its TTL allows, and
+		// no more than the DOT before it allowed
+		if i > 0 && ttl <= 0 {
+			return fmt.Errorf("DOT %s is beyond the TTL of the DOTs before it", hash)
+		}
+		if t := d.GetTTL(); i == 0 || t < ttl-1 {
+			ttl = t
+		} else {
+			ttl--
+		}
+		if exp := d.GetExpiry(); exp != nil {
+			if exp.Before(now) {
+				return fmt.Errorf("DOT %s expired at %s", hash, exp.Format(time.RFC3339))
+			}
+			if rv.Expiry == nil || exp.Before(*rv.Expiry) {
+				rv.Expiry = exp
+			}
+		}
+		p, err := adps.Parse(d.GetPermString())
+		if err != nil {
+			return fmt.Errorf("DOT %s: %v", hash, err)
+		}
+		perms = perms.Intersect(p)
+		if crypto.FmtKey(d.GetAccessURIMVK()) != rv.Namespace || !adps.Covers(d.GetAccessURISuffix(), suffix) {
+			return fmt.Errorf("DOT %s does not grant on %s", hash, rv.URI)
+		}
+		if resolve != nil {
+			v, err := resolve(hash)
+			if err != nil {
+				return err
+			}
+			if v != StateValid {
+				return fmt.Errorf("DOT %s is %s in the registry", hash, validityName(v))
+			}
+		}
+	}
+	rv.Permissions = perms.String()
+	if from != rv.Signer {
+		return fmt.Errorf("chain ends at %s, not at the signer %s", from, rv.Signer)
+	}
+	if !perms.Publish {
+		return fmt.Errorf("chain grants %s, which does not include publish", rv.Permissions)
+	}
+	return nil
+}
+
+func validityName(v RegistryValidity) string {
+	switch v {
+	case StateValid:
+		return "valid"
+	case StateExpired:
+		return "expired"
+	case StateRevoked:
+		return "revoked"
+	case StateUnknown:
+		return "unknown"
+	}
+	return "unresolvable"
+}
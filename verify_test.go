@@ -0,0 +1,241 @@
+package bw2bind
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/immesys/bw2/objects"
+)
+
+// packMessage encodes and signs a message in the BOSSWAVE wire form
+func packMessage(sk ed25519.PrivateKey, id uint64, mvk []byte, suffix string, ros, pos []packedObject) []byte {
+	b := &bytes.Buffer{}
+	b.WriteByte(0x01)
+	binary.Write(b, binary.LittleEndian, id)
+	binary.Write(b, binary.LittleEndian, uint16(0))
+	b.Write(mvk)
+	binary.Write(b, binary.LittleEndian, uint16(len(suffix)))
+	b.WriteString(suffix)
+	b.WriteByte(byte(len(ros)))
+	b.WriteByte(byte(len(pos)))
+	for _, ro := range ros {
+		b.WriteByte(byte(ro.Num))
+		binary.Write(b, binary.LittleEndian, uint16(len(ro.Content)))
+		b.Write(ro.Content)
+	}
+	for _, po := range pos {
+		binary.Write(b, binary.LittleEndian, uint32(po.Num))
+		binary.Write(b, binary.LittleEndian, uint32(len(po.Content)))
+		b.Write(po.Content)
+	}
+	return append(b.Bytes(), ed25519.Sign(sk, b.Bytes())...)
+}
+
+func testKey(b byte) (ed25519.PublicKey, ed25519.PrivateKey) {
+	sk := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{b}, ed25519.SeedSize))
+	return sk.Public().(ed25519.PublicKey), sk
+}
+
+func TestDecodePacked(t *testing.T) {
+	vk, sk := testKey(1)
+	ros := []packedObject{{objects.ROOriginVK, vk}, {objects.ROExpiry, []byte("12345678")}}
+	pos := []packedObject{{PONumString, []byte("hello")}, {PONumText, nil}}
+	buf := packMessage(sk, 42, vk, "a/b/c", ros, pos)
+	pm, err := decodePacked(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pm.Type != 0x01 || pm.MessageID != 42 || !bytes.Equal(pm.MVK, vk) || pm.Suffix != "a/b/c" {
+		t.Errorf("decoded header %d %d %x %q", pm.Type, pm.MessageID, pm.MVK, pm.Suffix)
+	}
+	if len(pm.ROs) != 2 || pm.ROs[1].Num != objects.ROExpiry || string(pm.ROs[1].Content) != "12345678" {
+		t.Errorf("decoded ROs %v", pm.ROs)
+	}
+	if len(pm.POs) != 2 || pm.POs[0].Num != PONumString || string(pm.POs[0].Content) != "hello" || len(pm.POs[1].Content) != 0 {
+		t.Errorf("decoded POs %v", pm.POs)
+	}
+	if err := pm.verifySignature(vk); err != nil {
+		t.Error(err)
+	}
+	for n := 0; n < len(buf); n++ {
+		if _, err := decodePacked(buf[:n]); err == nil {
+			t.Errorf("decoded a message truncated to %d of %d bytes", n, len(buf))
+		}
+	}
+	body := append(append([]byte{}, buf[:len(buf)-ed25519.SignatureSize]...), 0)
+	if _, err := decodePacked(append(body, buf[len(buf)-ed25519.SignatureSize:]...)); err == nil {
+		t.Error("decoded a message with trailing bytes")
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	vk, sk := testKey(1)
+	other, _ := testKey(2)
+	buf := packMessage(sk, 1, vk, "a", nil, []packedObject{{PONumString, []byte("hello")}})
+	tampered := append([]byte{}, buf...)
+	tampered[len(tampered)-ed25519.SignatureSize-1] ^= 1
+	tests := []struct {
+		name  string
+		buf   []byte
+		vk    []byte
+		valid bool
+	}{
+		{"valid", buf, vk, true},
+		{"payload changed", tampered, vk, false},
+		{"other signer", buf, other, false},
+		{"short VK", buf, vk[:31], false},
+	}
+	for _, tt := range tests {
+		pm, err := decodePacked(tt.buf)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if err := pm.verifySignature(tt.vk); (err == nil) != tt.valid {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	nsvk, nssk := testKey(1)
+	other, othersk := testKey(2)
+	packed := func(sk ed25519.PrivateKey, origin []byte) []byte {
+		return packMessage(sk, 7, nsvk, "a/b", []packedObject{{objects.ROOriginVK, origin}}, []packedObject{{PONumString, []byte("on")}})
+	}
+	msg := func(from []byte, blob []byte) *SimpleMessage {
+		return &SimpleMessage{
+			From: ToBase64(from),
+			URI:  ToBase64(nsvk) + "/a/b",
+			POs:  []PayloadObject{CreateBasePayloadObject(PONumBWMessage, blob)},
+		}
+	}
+	good := packed(nssk, nsvk)
+	check, err := msg(nsvk, good).Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if check.Signer != ToBase64(nsvk) || check.URI != ToBase64(nsvk)+"/a/b" || check.MessageID != 7 || check.ChainHash != "" {
+		t.Errorf("check is %+v", check)
+	}
+
+	wrongSig := msg(nsvk, good)
+	wrongSig.Signature = make([]byte, ed25519.SignatureSize)
+	tests := []struct {
+		name string
+		sm   *SimpleMessage
+		err  string
+	}{
+		{"not packed", &SimpleMessage{From: ToBase64(nsvk), POs: []PayloadObject{CreateStringPayloadObject("on")}}, ErrNotPacked.Error()},
+		{"signed by another key", msg(nsvk, packed(othersk, nsvk)), "not valid"},
+		{"claims another sender", msg(other, good), "claims to be from"},
+		{"signature differs from the packed one", wrongSig, "does not match"},
+		{"not the namespace and no chain", msg(other, packed(othersk, other)), "no DOT chain"},
+		{"garbled", msg(nsvk, good[:50]), "truncated"},
+	}
+	for _, tt := range tests {
+		if _, err := tt.sm.Verify(); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+	}
+}
+
+// chainLink is an access DOT for checkChain
+type chainLink struct {
+	hash, from, to, ns, suffix, perms string
+	ttl                               int
+	expiry                            *time.Time
+	invalid                           bool
+}
+
+func (d *chainLink) GetHash() []byte            { return []byte(d.hash) }
+func (d *chainLink) IsAccess() bool             { return true }
+func (d *chainLink) SigValid() bool             { return !d.invalid }
+func (d *chainLink) GetGiverVK() []byte         { b, _ := FromBase64(d.from); return b }
+func (d *chainLink) GetReceiverVK() []byte      { b, _ := FromBase64(d.to); return b }
+func (d *chainLink) GetTTL() int                { return d.ttl }
+func (d *chainLink) GetExpiry() *time.Time      { return d.expiry }
+func (d *chainLink) GetPermString() string      { return d.perms }
+func (d *chainLink) GetAccessURIMVK() []byte    { b, _ := FromBase64(d.ns); return b }
+func (d *chainLink) GetAccessURISuffix() string { return d.suffix }
+
+func TestCheckChain(t *testing.T) {
+	ns, a, b := testVK(1), testVK(2), testVK(3)
+	now := time.Unix(1500000000, 0)
+	later, past := now.Add(time.Hour), now.Add(-time.Hour)
+	link := func(from, to, suffix, perms string, ttl int, expiry *time.Time) *chainLink {
+		return &chainLink{hash: from[:4] + to[:4], from: from, to: to, ns: ns, suffix: suffix, perms: perms, ttl: ttl, expiry: expiry}
+	}
+	revokedHash := ""
+	resolve := func(hash string) (RegistryValidity, error) {
+		if hash == revokedHash {
+			return StateRevoked, nil
+		}
+		return StateValid, nil
+	}
+	wrongNS := link(ns, a, "a/*", "PC", 1, nil)
+	wrongNS.ns = b
+	badSig := link(ns, a, "a/*", "PC", 1, nil)
+	badSig.invalid = true
+	tests := []struct {
+		name    string
+		signer  string
+		suffix  string
+		dots    []*chainLink
+		revoked int
+		err     string
+	}{
+		{"valid", b, "a/b", []*chainLink{link(ns, a, "a/*", "C*P", 1, &later), link(a, b, "a/b", "PC", 0, nil)}, -1, ""},
+		{"single DOT", a, "a/b/c", []*chainLink{link(ns, a, "a/*", "P", 0, nil)}, -1, ""},
+		{"expired", b, "a/b", []*chainLink{link(ns, a, "a/*", "C*P", 1, nil), link(a, b, "a/b", "P", 0, &past)}, -1, "expired"},
+		{"revoked", b, "a/b", []*chainLink{link(ns, a, "a/*", "C*P", 1, nil), link(a, b, "a/b", "P", 0, nil)}, 0, "revoked"},
+		{"mismatched URI", b, "a/c", []*chainLink{link(ns, a, "a/*", "C*P", 1, nil), link(a, b, "a/b", "P", 0, nil)}, -1, "does not grant on"},
+		{"other namespace", a, "a/b", []*chainLink{wrongNS}, -1, "does not grant on"},
+		{"not from the namespace", b, "a/b", []*chainLink{link(a, b, "a/b", "P", 0, nil)}, -1, "expected " + ns},
+		{"broken chain", b, "a/b", []*chainLink{link(ns, a, "a/*", "P", 1, nil), link(b, b, "a/b", "P", 0, nil)}, -1, "expected " + a},
+		{"ends before the signer", b, "a/b", []*chainLink{link(ns, a, "a/*", "P", 1, nil)}, -1, "not at the signer"},
+		{"bad signature", a, "a/b", []*chainLink{badSig}, -1, "validly signed"},
+		{"beyond TTL", b, "a/b", []*chainLink{link(ns, a, "a/*", "P", 0, nil), link(a, b, "a/b", "P", 0, nil)}, -1, "beyond the TTL"},
+		{"no publish", b, "a/b", []*chainLink{link(ns, a, "a/*", "C*P", 1, nil), link(a, b, "a/b", "C", 0, nil)}, -1, "does not include publish"},
+		{"empty", b, "a/b", nil, -1, "empty"},
+	}
+	for _, tt := range tests {
+		revokedHash = ""
+		if tt.revoked >= 0 {
+			revokedHash = ToBase64([]byte(tt.dots[tt.revoked].hash))
+		}
+		dots := make([]chainDOT, len(tt.dots))
+		for i, d := range tt.dots {
+			dots[i] = d
+		}
+		rv := &MessageCheck{Signer: tt.signer, Namespace: ns, URI: ns + "/" + tt.suffix}
+		err := checkChain(rv, tt.suffix, dots, now, resolve)
+		if tt.err == "" {
+			if err != nil {
+				t.Errorf("%s: %v", tt.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: got %v, expected an error containing %q", tt.name, err, tt.err)
+		}
+	}
+
+	rv := &MessageCheck{Signer: b, Namespace: ns, URI: ns + "/a/b"}
+	dots := []chainDOT{link(ns, a, "a/*", "C*P", 1, &later), link(a, b, "a/b", "PC", 0, nil)}
+	if err := checkChain(rv, "a/b", dots, now, nil); err != nil {
+		t.Fatal(err)
+	}
+	if rv.Permissions != "CP" || rv.Expiry == nil || !rv.Expiry.Equal(later) {
+		t.Errorf("permissions %q expiry %v", rv.Permissions, rv.Expiry)
+	}
+	if err := checkChain(rv, "a/b", dots, now, func(string) (RegistryValidity, error) {
+		return StateError, errors.New("router down")
+	}); err == nil || err.Error() != "router down" {
+		t.Errorf("failed lookup gave %v", err)
+	}
+}
@@ -0,0 +1,148 @@
+package bw2bind
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/immesys/bw2bind/expr"
+)
+
+// viewRouter accepts views and view subscriptions, giving them handles if
+// handles is true. The requests that created views are sent on views so
+// that tests can push changes to them.
+func viewRouter(handles bool, views chan *frame) func(fr *frame, send func(*frame)) {
+	return func(fr *frame, send func(*frame)) {
+		var kv []string
+		if handles {
+			kv = []string{"handle", fr.Cmd + strconv.Itoa(fr.SeqNo)}
+		}
+		switch fr.Cmd {
+		case cmdMakeView:
+			send(okay(fr, append(kv, "id", "7")...))
+			views <- fr
+		case cmdSubscribeView, cmdSubscribe:
+			send(okay(fr, kv...))
+		case cmdUnsubscribe:
+			send(okay(fr))
+		}
+	}
+}
+
+func TestViewOnChangeDispatch(t *testing.T) {
+	views := make(chan *frame, 1)
+	router, cl := newFakeRouter(t, viewRouter(true, views))
+	v, err := cl.CreateView(expr.M{"uri": "ns/*"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := <-views
+	changes := make(chan int, 10)
+	n := 0
+	v.OnChange(func() {
+		n++
+		changes <- n
+		// callbacks may call back into the view
+		v.OnChange(func() {})
+		v.isClosed()
+	})
+	// before the fix the first change left the callback mutex locked,
+	// so the second change and any later OnChange hung
+	for i := 1; i <= 3; i++ {
+		router.send(result(req))
+		select {
+		case got := <-changes:
+			if got != i {
+				t.Fatalf("expected change %d, got %d", i, got)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("change %d was not dispatched", i)
+		}
+	}
+	within(t, 5*time.Second, "OnChange after changes", func() { v.OnChange(func() {}) })
+	within(t, 5*time.Second, "Close", func() {
+		if err := v.Close(); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func TestViewClose(t *testing.T) {
+	views := make(chan *frame, 1)
+	router, cl := newFakeRouter(t, viewRouter(true, views))
+	v, err := cl.CreateView(expr.M{"uri": "ns/*"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := <-views
+	ch, err := v.SubSlot("i.test", "s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	changed := make(chan struct{}, 10)
+	v.OnChange(func() { changed <- struct{}{} })
+	within(t, 5*time.Second, "Close", func() {
+		if err := v.Close(); err != nil {
+			t.Error(err)
+		}
+	})
+	select {
+	case _, ok := <-ch:
+		if ok {
+			t.Error("unexpected message on a closed view")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("subscription channel was not closed")
+	}
+	var handles []string
+	for _, f := range router.received(cmdUnsubscribe) {
+		h, _ := f.GetFirstHeader("handle")
+		handles = append(handles, h)
+	}
+	if len(handles) != 2 || handles[0] != cmdSubscribeView+"2" || handles[1] != cmdMakeView+"1" {
+		t.Errorf("expected the subscription and then the view to be unsubscribed, got %v", handles)
+	}
+	router.send(result(req))
+	select {
+	case <-changed:
+		t.Error("OnChange called after Close")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if err := v.Close(); err != nil {
+		t.Errorf("second Close: %v", err)
+	}
+	if _, err := v.List(); err != ErrViewClosed {
+		t.Errorf("List after Close gave %v", err)
+	}
+	if _, err := v.SubSignal("i.test", "s"); err != ErrViewClosed {
+		t.Errorf("SubSignal after Close gave %v", err)
+	}
+}
+
+func TestViewCloseWithoutHandle(t *testing.T) {
+	views := make(chan *frame, 1)
+	router, cl := newFakeRouter(t, viewRouter(false, views))
+	v, err := cl.CreateView(expr.M{"uri": "ns/*"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-views
+	ch, err := v.SubSlot("i.test", "s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Close(); err != ErrViewHandleMissing {
+		t.Errorf("expected ErrViewHandleMissing, got %v", err)
+	}
+	select {
+	case _, ok := <-ch:
+		if ok {
+			t.Error("unexpected message on a closed view")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("subscription channel was not closed")
+	}
+	if n := len(router.received(cmdUnsubscribe)); n != 0 {
+		t.Errorf("expected no unsubscribe without handles, got %d", n)
+	}
+}
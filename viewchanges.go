@@ -0,0 +1,133 @@
+package bw2bind
+
+import (
+	"reflect"
+	"sort"
+
+	log "github.com/cihub/seelog"
+)
+
+type ViewEventType int
+
+const (
+	// The first event on a Changes channel, carrying the full interface list
+	ViewSnapshot ViewEventType = iota
+	// An interface now matches the view
+	InterfaceAdded
+	// An interface no longer matches the view
+	InterfaceRemoved
+	// An interface still matches the view but its metadata changed
+	MetadataChanged
+)
+
+func (t ViewEventType) String() string {
+	switch t {
+	case ViewSnapshot:
+		return "snapshot"
+	case InterfaceAdded:
+		return "added"
+	case InterfaceRemoved:
+		return "removed"
+	case MetadataChanged:
+		return "metadata changed"
+	}
+	return "unknown"
+}
+
+// ViewEvent describes a change in the interfaces matching a View
+type ViewEvent struct {
+	Type ViewEventType
+	// The interface that was added, removed or changed. For MetadataChanged
+	// this is the new descriptor.
+	Interface *InterfaceDescriptor
+	// For MetadataChanged, the descriptor before the change
+	Previous *InterfaceDescriptor
+	// For ViewSnapshot, every interface matching the view
+	Snapshot []*InterfaceDescriptor
+}
+
+// Changes returns a channel that receives a ViewSnapshot event with the
+// current interfaces, followed by an event for every interface that is
+// added, removed or has its metadata changed. Each call returns a new
+// channel, which is closed when the view is closed. Changes that arrive
+// while the receiver is busy are coalesced.
+func (v *View) Changes() <-chan *ViewEvent {
+	rv := make(chan *ViewEvent, 10)
+	notify := make(chan struct{}, 1)
+	v.OnChange(func() {
+		select {
+		case notify <- struct{}{}:
+		default:
+		}
+	})
+	send := func(ev *ViewEvent) bool {
+		select {
+		case rv <- ev:
+			return true
+		case <-v.done:
+			return false
+		}
+	}
+	go func() {
+		defer close(rv)
+		var prev map[string]*InterfaceDescriptor
+		for {
+			list, err := v.List()
+			if err == ErrViewClosed {
+				return
+			} else if err != nil {
+				log.Warn("could not list view: ", err)
+			} else if prev == nil {
+				if !send(&ViewEvent{Type: ViewSnapshot, Snapshot: list}) {
+					return
+				}
+				prev = indexInterfaces(list)
+			} else {
+				cur := indexInterfaces(list)
+				for _, ev := range diffInterfaces(prev, cur) {
+					if !send(ev) {
+						return
+					}
+				}
+				prev = cur
+			}
+			select {
+			case <-notify:
+			case <-v.done:
+				return
+			}
+		}
+	}()
+	return rv
+}
+
+func indexInterfaces(list []*InterfaceDescriptor) map[string]*InterfaceDescriptor {
+	rv := make(map[string]*InterfaceDescriptor, len(list))
+	for _, ifd := range list {
+		rv[ifd.URI] = ifd
+	}
+	return rv
+}
+
+// diffInterfaces returns the events that turn prev into cur, ordered by URI
+func diffInterfaces(prev, cur map[string]*InterfaceDescriptor) []*ViewEvent {
+	rv := []*ViewEvent{}
+	for uri, ifd := range cur {
+		old, ok := prev[uri]
+		switch {
+		case !ok:
+			rv = append(rv, &ViewEvent{Type: InterfaceAdded, Interface: ifd})
+		case !reflect.DeepEqual(old.Metadata, ifd.Metadata):
+			rv = append(rv, &ViewEvent{Type: MetadataChanged, Interface: ifd, Previous: old})
+		}
+	}
+	for uri, ifd := range prev {
+		if _, ok := cur[uri]; !ok {
+			rv = append(rv, &ViewEvent{Type: InterfaceRemoved, Interface: ifd})
+		}
+	}
+	sort.Slice(rv, func(i, j int) bool {
+		return rv[i].Interface.URI < rv[j].Interface.URI
+	})
+	return rv
+}
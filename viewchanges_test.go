@@ -0,0 +1,54 @@
+package bw2bind
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffInterfaces(t *testing.T) {
+	ifd := func(uri string, md ...string) *InterfaceDescriptor {
+		rv := &InterfaceDescriptor{URI: uri, Metadata: map[string]string{}}
+		for i := 0; i+1 < len(md); i += 2 {
+			rv.Metadata[md[i]] = md[i+1]
+		}
+		return rv
+	}
+	index := func(ifds ...*InterfaceDescriptor) map[string]*InterfaceDescriptor {
+		return indexInterfaces(ifds)
+	}
+	type ev struct {
+		typ  ViewEventType
+		uri  string
+		prev bool
+	}
+	tests := []struct {
+		name      string
+		prev, cur map[string]*InterfaceDescriptor
+		want      []ev
+	}{
+		{"both empty", index(), index(), []ev{}},
+		{"unchanged", index(ifd("a", "k", "v")), index(ifd("a", "k", "v")), []ev{}},
+		{"added", index(), index(ifd("b"), ifd("a")), []ev{{InterfaceAdded, "a", false}, {InterfaceAdded, "b", false}}},
+		{"removed", index(ifd("a"), ifd("b")), index(ifd("b")), []ev{{InterfaceRemoved, "a", false}}},
+		{"metadata value changed", index(ifd("a", "k", "v")), index(ifd("a", "k", "w")), []ev{{MetadataChanged, "a", true}}},
+		{"metadata key added", index(ifd("a")), index(ifd("a", "k", "v")), []ev{{MetadataChanged, "a", true}}},
+		{
+			"mixed, ordered by URI",
+			index(ifd("c"), ifd("b", "k", "v"), ifd("d")),
+			index(ifd("a"), ifd("b", "k", "w"), ifd("d")),
+			[]ev{{InterfaceAdded, "a", false}, {MetadataChanged, "b", true}, {InterfaceRemoved, "c", false}},
+		},
+	}
+	for _, tt := range tests {
+		got := []ev{}
+		for _, e := range diffInterfaces(tt.prev, tt.cur) {
+			got = append(got, ev{e.Type, e.Interface.URI, e.Previous != nil})
+			if e.Previous != nil && e.Previous != tt.prev[e.Interface.URI] {
+				t.Errorf("%s: previous of %s is not the old descriptor", tt.name, e.Interface.URI)
+			}
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, expected %v", tt.name, got, tt.want)
+		}
+	}
+}